@@ -0,0 +1,78 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"golangfinal/mailer"
+)
+
+// validEnv is the least the server needs to start
+func validEnv(t *testing.T) {
+	t.Setenv("SECRET_LOVE", "a secret only for the tests")
+	t.Setenv("MAILER", "outbox")
+}
+
+func TestLoadSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{"valid", nil, ""},
+		{"no secret and no keys", map[string]string{"SECRET_LOVE": ""}, "tokens:"},
+		{"keys dir missing", map[string]string{"JWT_KEYS_DIR": "/no/such/dir"}, "tokens.keys_dir"},
+		{"no mailer", map[string]string{"MAILER": ""}, "mail.mailer"},
+		{"unknown mailer", map[string]string{"MAILER": "smtps"}, "mail.mailer"},
+		{"smtp without a server", map[string]string{"MAILER": "smtp", "MAIL_FROM": "shop@example.com"}, "mail.smtp_host"},
+		{"smtp without a sender", map[string]string{"MAILER": "smtp", "SMTP_HOST": "mail.example.com"}, "mail.from"},
+		{"smtp", map[string]string{"MAILER": "smtp", "SMTP_HOST": "mail.example.com", "MAIL_FROM": "shop@example.com"}, ""},
+		{"provider without a client id", map[string]string{"OIDC_PROVIDERS": "google", "OIDC_GOOGLE_ISSUER": "https://accounts.google.com"}, "oidc_providers.google.client_id"},
+		{"provider name", map[string]string{"OIDC_PROVIDERS": "go ogle"}, "oidc_providers"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			validEnv(t)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			_, err := Load("")
+			if test.wantErr == "" && err != nil {
+				t.Fatal(err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("error = %v, want one about %s", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadProvidersAndMailer(t *testing.T) {
+	validEnv(t)
+	t.Setenv("OIDC_PROVIDERS", "google, My-IdP")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "shop")
+	t.Setenv("OIDC_GOOGLE_CLIENT_SECRET", "google-secret")
+	t.Setenv("OIDC_MY_IDP_ISSUER", "http://localhost:8080/realms/shop")
+	t.Setenv("OIDC_MY_IDP_CLIENT_ID", "shop")
+	t.Setenv("OIDC_MY_IDP_SCOPES", "email groups")
+	t.Setenv("MAILER", "smtp")
+	t.Setenv("SMTP_HOST", "mail.example.com")
+	t.Setenv("SMTP_PASSWORD", "smtp-secret")
+	t.Setenv("MAIL_FROM", "shop@example.com")
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers := cfg.Providers()
+	if google := providers["google"]; google.Client_Secret != "google-secret" || google.Issuer != "https://accounts.google.com" {
+		t.Errorf("google = %+v", google)
+	}
+	if idp := providers["my-idp"]; strings.Join(idp.Scopes, " ") != "email groups" || idp.Client_ID != "shop" {
+		t.Errorf("my-idp = %+v", idp)
+	}
+	smtp, ok := cfg.Mail.NewMailer().(*mailer.SMTPMailer)
+	if !ok || smtp.Host != "mail.example.com" || smtp.Port != 587 || smtp.Password != "smtp-secret" || smtp.Timeout != mailer.DefaultTimeout {
+		t.Errorf("mailer = %+v, want the smtp server of the environment", cfg.Mail.NewMailer())
+	}
+}
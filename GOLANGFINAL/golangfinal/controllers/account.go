@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/models"
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+/*
+ExportData gives the user everything the shop holds about them, GET /users/export.
+by default it is a ZIP with one JSON file per part, ?format=json gives a single JSON document
+*/
+func ExportData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), LongQueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		reviews, err := database.FindReviews(ctx, ProductCollection, user.User_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		sessions, err := database.ListSessions(ctx, SessionCollection, user.User_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		export := models.DataExport{
+			Exported_At:     time.Now(),
+			Profile:         user.Profile(),
+			Cart:            emptyIfNil(user.UserCart),
+			Saved_For_Later: emptyIfNil(user.Saved_For_Later),
+			Wishlists:       user.Wishlists,
+			Orders:          user.Order_Status,
+			Reviews:         reviews,
+			Sessions:        sessions,
+		}
+		if export.Wishlists == nil {
+			export.Wishlists = make([]models.Wishlist, 0)
+		}
+		if export.Orders == nil {
+			export.Orders = make([]models.Order, 0)
+		}
+		if c.Query("format") == "json" {
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.json"`, user.User_ID))
+			c.JSON(http.StatusOK, export)
+			return
+		}
+		archive, err := exportArchive(export)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot write the export archive", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": database.ErrCantExportData.Error()})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.zip"`, user.User_ID))
+		c.Data(http.StatusOK, "application/zip", archive)
+	}
+}
+
+// exportArchive writes every part of the export into its own file of a ZIP
+func exportArchive(export models.DataExport) ([]byte, error) {
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"addresses.json", export.Profile.Address_Details},
+		{"cart.json", export.Cart},
+		{"saved_for_later.json", export.Saved_For_Later},
+		{"wishlists.json", export.Wishlists},
+		{"orders.json", export.Orders},
+		{"reviews.json", export.Reviews},
+		{"sessions.json", export.Sessions},
+	}
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.Exported_At})
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func emptyIfNil(items []models.ProductUser) []models.ProductUser {
+	if items == nil {
+		return make([]models.ProductUser, 0)
+	}
+	return items
+}
+
+/*
+DeleteAccount erases the account, POST /users/deleteaccount {"password": ..., "code": ...}.
+the password is needed when the account has one, the code when two factor authentication is on.
+the personal data is removed and the user is logged out everywhere, the orders and the reviews
+stay without anything that names the user. the saved responses of the idempotency keys, the failed
+logins of the account and of the addresses it logged in from and the guest cart of the request
+are deleted too. reviews written before the author was saved with them can't be found, they stay as they are
+*/
+func DeleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Password string `json:"password"`
+			Code     string `json:"code"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), LongQueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		//with two factor authentication on the code of the app is asked too
+		var code *string
+		if user.TOTP_Enabled {
+			code = &body.Code
+		}
+		if !confirmUser(ctx, c, user, body.Password, code) {
+			return
+		}
+		//the addresses are read before the sessions are deleted
+		ips, err := database.SessionIPs(ctx, SessionCollection, user.User_ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": database.ErrCantDeleteAccount.Error()})
+			return
+		}
+		attempts := []string{database.IPAttemptKey(c.ClientIP())}
+		for _, ip := range ips {
+			attempts = append(attempts, database.IPAttemptKey(ip))
+		}
+		if user.Email != nil {
+			attempts = append(attempts, database.AccountAttemptKey(*user.Email))
+		}
+		//the reviews first, the user is still there to try again if it fails
+		err = database.AnonymizeReviews(ctx, ProductCollection, user.User_ID)
+		if err == nil {
+			err = database.AnonymizeUser(ctx, UserCollection, user.User_ID)
+		}
+		if err == nil {
+			err = database.DeleteUserRecords(ctx, user.User_ID, SessionCollection, VerificationCollection)
+		}
+		if err == nil {
+			err = database.DeleteIdempotencyKeys(ctx, IdempotencyCollection, user.User_ID)
+		}
+		if err == nil {
+			err = database.ForgetLoginAttempts(ctx, LoginAttemptCollection, attempts...)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		//a guest cart is deleted when it is merged, only the one the client still holds can be left
+		if token := c.GetHeader(CartTokenHeader); token != "" {
+			if cartID, err := generate.ValidateGuestCartToken(token); err == nil {
+				_ = database.DeleteGuestCart(ctx, GuestCartCollection, cartID)
+			}
+		}
+		c.JSON(http.StatusOK, "Your account was deleted")
+	}
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestDeleteAccountAttempts(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("a wrong password counts as a failed login and deletes nothing", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		useFastPolicy(mt)
+		user := testUser(true)
+		hash, err := HashPassword("the right password")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user.Password = &hash
+		failure := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "failures", Value: 1}}})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+			mtest.CreateCursorResponse(0, "shop.LoginAttempts", mtest.FirstBatch),
+			failure, failure,
+		)
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("uid", user.User_ID) })
+		router.POST("/users/deleteaccount", DeleteAccount())
+		request := httptest.NewRequest(http.MethodPost, "/users/deleteaccount", strings.NewReader(`{"password": "a guess"}`))
+		request.Header.Set("Content-Type", "application/json")
+		if response := serve(router, request); response.Code != http.StatusForbidden {
+			mt.Fatalf("deleteaccount = %d %s, want %d", response.Code, response.Body, http.StatusForbidden)
+		}
+		want := []string{"find", "aggregate", "findAndModify", "findAndModify"}
+		if sent := commands(mt); strings.Join(sent, ",") != strings.Join(want, ",") {
+			mt.Errorf("the database got %v, want %v", sent, want)
+		}
+	})
+}
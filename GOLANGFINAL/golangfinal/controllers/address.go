@@ -2,9 +2,8 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"net/http"
-	"time"
 
 	"golangfinal/models"
 
@@ -61,7 +60,9 @@ func AddAddress() gin.HandlerFunc {
 			c.IndentedJSON(http.StatusNotAcceptable, err.Error())
 		}
 		//дочерний            //родительский контекст
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		//Canceling this context releases resources associated with it
+		defer cancel()
 		/*
 			what is bson?
 			it is a binary representation of the stored documents used in MongoDb
@@ -101,7 +102,9 @@ func AddAddress() gin.HandlerFunc {
 		//ctx is being passed to the aggregate function to ensure that the operation doesnt block indefinitely
 
 		if err != nil {
+			slog.ErrorContext(ctx, "cannot count the addresses", "error", err)
 			c.IndentedJSON(500, "Internal Server Error")
+			return
 		}
 
 		var addressinfo []bson.M
@@ -111,7 +114,9 @@ func AddAddress() gin.HandlerFunc {
 		///START FROM HERE
 		if err = pointcursor.All(ctx, &addressinfo); err != nil {
 			//All() function is used to retrieve all of the documents in a MongoDB collection.
-			panic(err)
+			slog.ErrorContext(ctx, "cannot read the addresses", "error", err)
+			c.IndentedJSON(500, "Internal Server Error")
+			return
 		}
 
 		var size int32
@@ -138,14 +143,12 @@ func AddAddress() gin.HandlerFunc {
 			//and ull need to add more
 			_, err := UserCollection.UpdateOne(ctx, filter, update)
 			if err != nil {
-				fmt.Println(err)
+				slog.ErrorContext(ctx, "cannot add the address", "error", err)
 			}
 			c.IndentedJSON(200,"Successfully added your address!")
 		} else {
 			c.IndentedJSON(400, "Not Allowed ")
 		}
-		//Canceling this context releases resources associated with it
-		defer cancel()
 		ctx.Done()
 	}
 }
@@ -168,7 +171,7 @@ func EditHomeAddress() gin.HandlerFunc {
 		if err := c.BindJSON(&editaddress); err != nil {
 			c.IndentedJSON(http.StatusBadRequest, err.Error())
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		//filtering by the id of the specifical user that want to change it's home address
 		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
@@ -204,7 +207,7 @@ func EditWorkAddress() gin.HandlerFunc {
 		if err := c.BindJSON(&editaddress); err != nil {
 			c.IndentedJSON(http.StatusBadRequest, err.Error())
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
 		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house_name", Value: editaddress.House}, {Key: "address.1.street_name", Value: editaddress.Street}, {Key: "address.1.city_name", Value: editaddress.City}, {Key: "address.1.pin_code", Value: editaddress.Pincode}}}}
@@ -240,7 +243,7 @@ func DeleteAddress() gin.HandlerFunc {
 		if err != nil {
 			c.IndentedJSON(500, "Internal Server Error")
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		//why d u have timeout?
 		//whenever a server is working w a database, u cant have it endlessly waiting for the
 		//result(fr ex if the server is going down)
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/models"
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var APIKeyCollection *mongo.Collection
+
+/*
+CreateAPIKey issues a key for a service, POST /admin/apikeys
+{"name": "erp", "scopes": ["products:write"], "expires_in_days": 90}.
+the key is in the answer and can't be seen again
+*/
+func CreateAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			models.APIKey
+			Expires_In_Days int `json:"expires_in_days" validate:"min=0,max=3650"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, scope := range body.Scopes {
+			if !generate.KnownScope(scope) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope, "scopes": generate.Scopes})
+				return
+			}
+			//nobody hands out more than they have
+			if !generate.ScopeGranted(c.GetStringSlice("scopes"), scope) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "you can't grant the scope " + scope})
+				return
+			}
+		}
+		apiKey := body.APIKey
+		apiKey.Expires_At = nil
+		if body.Expires_In_Days > 0 {
+			expires := time.Now().AddDate(0, 0, body.Expires_In_Days)
+			apiKey.Expires_At = &expires
+		}
+		apiKey.Created_By = c.GetString("uid")
+		if apiKey.Created_By == "" {
+			apiKey.Created_By = "api_key:" + c.GetString("api_key")
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		apiKey, key, err := database.CreateAPIKey(ctx, APIKeyCollection, apiKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": apiKey})
+	}
+}
+
+// ListAPIKeys lists the keys without their secrets, GET /admin/apikeys
+func ListAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		apiKeys, err := database.ListAPIKeys(ctx, APIKeyCollection)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, apiKeys)
+	}
+}
+
+// RevokeAPIKey stops a key from working, DELETE /admin/apikeys?id=
+func RevokeAPIKey() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyID := c.Query("id")
+		if keyID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "api key id is empty"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err := database.RevokeAPIKey(ctx, APIKeyCollection, keyID)
+		if err == database.ErrCantFindAPIKey {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully revoked the api key")
+	}
+}
@@ -3,11 +3,11 @@ package controllers
 import (
 	"context"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
-	"time"
 
 	"golangfinal/database"
+	"golangfinal/metrics"
 	"golangfinal/models"
 
 	"github.com/gin-gonic/gin"
@@ -15,16 +15,20 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
-//2 types of collections:product collection, user collection
+//4 types of collections:product collection, user collection, tax rule collection, exchange rate collection
 type Application struct {
 	prodCollection *mongo.Collection
 	userCollection *mongo.Collection
+	taxCollection  *mongo.Collection
+	rateCollection *mongo.Collection
 }
 //function that creates an intance of 'Application' struct
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
+func NewApplication(prodCollection, userCollection, taxCollection, rateCollection *mongo.Collection) *Application {
 	return &Application{
 		prodCollection: prodCollection,
 		userCollection: userCollection,
+		taxCollection:  taxCollection,
+		rateCollection: rateCollection,
 	}
 }
 
@@ -35,34 +39,30 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 		productQueryID := c.Query("id")
 		//if it is empty
 		if productQueryID == "" {
-			log.Println("product id is empty")
 			//stop the program there
 			//use 'blank item' cs we dont need to use whatever is returned
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
 			return
 		}
-		//check for user id 
-		userQueryID := c.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
-			return
-		}
+		//the cart is always the one of the logged in user, never one named in the query
+		userQueryID := c.GetString("uid")
 		//ObjectIDFromHex creates a new ObjectID from a hexadecimal string.
 		// It returns an error if the hex string is not a valid ObjectID.
 		productID, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
-			log.Println(err)
+			slog.WarnContext(c.Request.Context(), "invalid product id", "error", err)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		//calling the database function
 		err = database.AddProductToCart(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
 		if err != nil {
 			c.IndentedJSON(http.StatusInternalServerError, err)
+			return
 		}
+		metrics.AddedToCart("user")
 		//if there were no errors
 		c.IndentedJSON(200, "Successfully Added to the cart")
 	}
@@ -72,25 +72,20 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		productQueryID := c.Query("id")
 		if productQueryID == "" {
-			log.Println("product id is inavalid")
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
 			return
 		}
 
-		userQueryID := c.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
-		}
+		userQueryID := c.GetString("uid")
 
 		ProductID, err := primitive.ObjectIDFromHex(productQueryID)
 		if err != nil {
-			log.Println(err)
+			slog.WarnContext(c.Request.Context(), "invalid product id", "error", err)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		err = database.RemoveCartItem(ctx, app.prodCollection, app.userCollection, ProductID, userQueryID)
 		if err != nil {
@@ -103,17 +98,11 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 
 func GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid id"})
-			c.Abort()
-			return
-		}
+		user_id := c.GetString("uid")
 
 		usert_id, _ := primitive.ObjectIDFromHex(user_id)
 		
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		
 
@@ -126,63 +115,67 @@ func GetItemFromCart() gin.HandlerFunc {
 		//finding the right user
 		err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(&filledcart)
 		if err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot find the user", "error", err)
 			c.IndentedJSON(500, "not id found")
 			return
 		}
-		//getting his/her data
-		filter_match := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: usert_id}}}}
-		/*
-		What does unwinding mean in mongodb?
-		1.Document in mongodb  - stores info abt 1 object
-		2.If you have a document with an array field containing multiple values ([]ProductUser, []Address,[]Order)
-		3.U can use the unwind feature to split the array into separate documents
-		fr ex, "favorite_colors"="blue","green" ->unwind-> "blue" of type 'favorite_colors', "green" of type "favorite_colors"
-		*/
-
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-		//group all the values there with the help of id
-		//finding the total price of all of the values in that user's cart
-
-
-		pointcursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filter_match, unwind, grouping})
+		//the tax of every line depends on the shipping address and the product category
+		rules, err := database.GetTaxRules(ctx, TaxRuleCollection)
 		if err != nil {
-			log.Println(err)
-		}
-
-		//json to golang understandable data conversion???
-		var listing []bson.M
-		//iterating the pointcursor and decoding each document into listing
-		if err = pointcursor.All(ctx, &listing); err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot get the tax rules", "error", err)
 			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		taxlines, totaltax, totalprice, err := database.CalculateTax(rules, database.ShippingAddress(filledcart), filledcart.UserCart)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot calculate the tax of the cart", "error", err)
+			c.IndentedJSON(http.StatusConflict, err.Error())
+			return
+		}
+		//the prices are also shown in the currency the user has chosen
+		rate, err := database.GetExchangeRate(ctx, RateCollection, c.Query("currency"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		display, err := rate.Applied(totalprice)
+		if err == nil {
+			err = database.DisplayCart(rate, filledcart.UserCart)
 		}
-		//ignoring the index
-		for _, json := range listing {
-		//so that all the data that u send to the user is the proper JSON
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledcart.UserCart)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot convert the prices of the cart", "error", err)
+			c.IndentedJSON(http.StatusConflict, database.ErrCantConvertPrices.Error())
+			return
 		}
+		c.IndentedJSON(200, gin.H{
+			"total":           totalprice,
+			"total_tax":       totaltax,
+			"usercart":        filledcart.UserCart,
+			"saved_for_later": filledcart.Saved_For_Later,
+			"tax_lines":       taxlines,
+			"exchange_rate":   display,
+		})
 		ctx.Done()
 	}
 }
 //START FROM HERE
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userQueryID := c.Query("id")
-		if userQueryID == "" {
-			log.Panicln("user id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
-		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		userQueryID := c.GetString("uid")
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 
 		//calling the function from the database package
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryID)
+		//?acknowledge= is the digest of the cart changes the user has already seen
+		err := database.BuyItemFromCart(ctx, app.prodCollection, app.userCollection, app.taxCollection, app.rateCollection, userQueryID, c.Query("currency"), c.Query("acknowledge"))
+		var changed *database.CartChangedError
+		if errors.As(err, &changed) {
+			c.IndentedJSON(http.StatusConflict, gin.H{"error": err.Error(), "changes": changed.Changes})
+			return
+		}
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutStatus(err), err.Error())
+			return
 		}
 		c.IndentedJSON(200, "Successfully Placed the order")
 	}
@@ -190,30 +183,39 @@ func (app *Application) BuyFromCart() gin.HandlerFunc {
 
 func (app *Application) InstantBuy() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		UserQueryID := c.Query("userid")
-		if UserQueryID == "" {
-			log.Println("UserID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("UserID is empty"))
-		}
+		UserQueryID := c.GetString("uid")
 		ProductQueryID := c.Query("pid")
 		if ProductQueryID == "" {
-			log.Println("Product_ID id is empty")
 			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product_id is empty"))
+			return
 		}
 		productID, err := primitive.ObjectIDFromHex(ProductQueryID)
 		if err != nil {
-			log.Println(err)
+			slog.WarnContext(c.Request.Context(), "invalid product id", "error", err)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		//calling the function from the database package
-		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productID, UserQueryID)
+		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, app.taxCollection, app.rateCollection, productID, UserQueryID, c.Query("currency"))
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(checkoutStatus(err), err.Error())
+			return
 		}
 		c.IndentedJSON(200, "Successully placed the order")
 	}
 }
+
+func checkoutStatus(err error) int {
+	switch err {
+	case database.ErrOutOfStock, database.ErrCartIsEmpty:
+		return http.StatusConflict
+	case database.ErrCantFindProduct:
+		return http.StatusNotFound
+	case database.ErrUserIDIsNotValid, database.ErrUnknownCurrency:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
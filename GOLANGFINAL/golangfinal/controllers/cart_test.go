@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// cartRouter serves the cart routes for the user uid, like the authentication middleware would
+func cartRouter(uid string) *gin.Engine {
+	app := NewApplication(ProductCollection, UserCollection, TaxRuleCollection, RateCollection)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("uid", uid) })
+	router.GET("/addtocart", app.AddToCart())
+	router.GET("/removeitem", app.RemoveItem())
+	router.GET("/listcart", GetItemFromCart())
+	return router
+}
+
+func TestCartOfTheLoggedInUser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	owner, other, product := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	updated := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	//the old query parameters name another user, they must be ignored
+	query := "?id=" + product.Hex() + "&userID=" + other.Hex() + "&userid=" + other.Hex()
+
+	tests := []struct {
+		name      string
+		path      string
+		responses func(mt *mtest.T) []bson.D
+		//filter is where the _id of the user is in the command
+		filter func(command bson.Raw) bson.RawValue
+	}{
+		{"add", "/addtocart" + query, func(mt *mtest.T) []bson.D {
+			line := models.ProductUser{Product_ID: product, Price: models.NewMoney(1000, "USD")}
+			return []bson.D{mtest.CreateCursorResponse(0, "shop.Products", mtest.FirstBatch, document(mt, line)), updated}
+		}, func(command bson.Raw) bson.RawValue {
+			return command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q", "_id")
+		}},
+		{"remove", "/removeitem" + query, func(mt *mtest.T) []bson.D {
+			return []bson.D{updated}
+		}, func(command bson.Raw) bson.RawValue {
+			return command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q", "_id")
+		}},
+		{"list", "/listcart" + query, func(mt *mtest.T) []bson.D {
+			user := models.User{ID: owner, UserCart: []models.ProductUser{}}
+			return []bson.D{mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+				mtest.CreateCursorResponse(0, "shop.TaxRules", mtest.FirstBatch)}
+		}, func(command bson.Raw) bson.RawValue {
+			return command.Lookup("filter", "_id")
+		}},
+	}
+	for _, test := range tests {
+		mt.Run(test.name, func(mt *mtest.T) {
+			useMockDatabase(mt)
+			mt.AddMockResponses(test.responses(mt)...)
+			response := serve(cartRouter(owner.Hex()), httptest.NewRequest(http.MethodGet, test.path, nil))
+			if response.Code != http.StatusOK {
+				mt.Fatalf("%s = %d %s", test.path, response.Code, response.Body)
+			}
+			var touched int
+			for _, event := range mt.GetAllStartedEvents() {
+				if event.CommandName != "update" && !(event.CommandName == "find" && test.name == "list") {
+					continue
+				}
+				id := test.filter(event.Command)
+				if id.Type != bson.TypeObjectID {
+					continue
+				}
+				touched++
+				if id.ObjectID() != owner {
+					mt.Errorf("%s %s, want the cart of %s", event.CommandName, event.Command, owner.Hex())
+				}
+			}
+			if touched == 0 {
+				mt.Error("no command was made for the cart of the user")
+			}
+		})
+	}
+}
@@ -2,9 +2,8 @@ package controllers
 
 import (
   "context"
-  "fmt"
+  "log/slog"
   "net/http"
-  "time"
 
   "golangfinal/models"
 	//"log"
@@ -54,12 +53,13 @@ func AddComment() gin.HandlerFunc {
     if err = c.BindJSON(&comments); err != nil {
       c.IndentedJSON(http.StatusNotAcceptable, err.Error())
     }
-    var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+    comments.User_ID = c.GetString("uid")
+    var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
     filter := bson.D{primitive.E{Key: "_id", Value: comment}}
     update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "comment", Value: comments}}}}
     _, err =ProductCollection.UpdateOne(ctx, filter, update)
     if err != nil {
-      fmt.Println(err)
+      slog.ErrorContext(ctx, "cannot add the comment", "error", err)
     }
     c.IndentedJSON(200,"Successfully added your comment!")
     defer cancel()
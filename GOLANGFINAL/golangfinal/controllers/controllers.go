@@ -2,13 +2,14 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	"golangfinal/database"
+	"golangfinal/metrics"
 	"golangfinal/models"
+	"golangfinal/passwords"
 	generate "golangfinal/tokens"
-	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
+	"regexp"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,36 +17,60 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // userCollection is of type mongo.Collection
-var UserCollection *mongo.Collection = database.UserData(database.Client, "Users")
-var ProductCollection *mongo.Collection = database.ProductData(database.Client, "Products")
+var UserCollection *mongo.Collection
+var ProductCollection *mongo.Collection
+var TaxRuleCollection *mongo.Collection
+var RateCollection *mongo.Collection
+var GuestCartCollection *mongo.Collection
+var IdempotencyCollection *mongo.Collection
+
+// UseDatabase points the collections of the handlers at the database of the client, main calls it before the server starts
+func UseDatabase(client *mongo.Client) {
+	UserCollection = database.Collection(client, "Users")
+	ProductCollection = database.Collection(client, "Products")
+	TaxRuleCollection = database.Collection(client, "TaxRules")
+	RateCollection = database.Collection(client, "ExchangeRates")
+	GuestCartCollection = database.Collection(client, "GuestCarts")
+	IdempotencyCollection = database.Collection(client, "IdempotencyKeys")
+	SessionCollection = database.Collection(client, "Sessions")
+	APIKeyCollection = database.Collection(client, "APIKeys")
+	LoginAttemptCollection = database.Collection(client, "LoginAttempts")
+	VerificationCollection = database.Collection(client, "VerificationTokens")
+}
+
+// how long a handler waits for the database, main sets them from the configuration
+var QueryTimeout = 10 * time.Second
+
+// LongQueryTimeout is for the handlers that do a lot, like the data export, or call other servers
+var LongQueryTimeout = 30 * time.Second
+
+// requestContext carries the request id and the user into the log lines of the database calls,
+// unlike the request it isn't canceled when the client goes away, so no write stops halfway
+func requestContext(c *gin.Context) context.Context {
+	return context.WithoutCancel(c.Request.Context())
+}
 
 // from the validator package creating a new instance of the validator
 var Validate = validator.New()
 
 // to protect the password from getting accessed through the database
-func HashPassword(password string) string {
-	//passing the slice of bytes
-	//14 characters long hash
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	if err != nil {
-		log.Panic(err)
-	}
-	return string(bytes)
+// the algorithm and its parameters come from the password policy
+func HashPassword(password string) (string, error) {
+	return passwords.Current.Hash(password)
 }
 
 // checkin if the password the user gives is the same as in the database
 func VerifyPassword(userpassword string, givenpassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(givenpassword), []byte(userpassword))
-	//flag
-	valid := true
-	msg := ""
+	valid, err := passwords.Current.Verify(userpassword, givenpassword)
 	if err != nil {
+		slog.Error("cannot verify the password", "error", err)
+	}
+	msg := ""
+	if !valid {
 		msg = "Login Or Passowrd is Incorerct"
-		valid = false
 	}
 	return valid, msg
 }
@@ -57,7 +82,7 @@ func SignUp() gin.HandlerFunc {
 	//signup function returns a gin handler func
 	//->returning a function
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		var user models.User
 		if err := c.BindJSON(&user); err != nil {
@@ -69,34 +94,47 @@ func SignUp() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr})
 			return
 		}
+		//emails are saved in lower case, Foo@x.com and foo@x.com are the same account
+		*user.Email = database.NormalizeEmail(*user.Email)
 		//counter of non-unique emails
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
 		if err != nil {
-			log.Panic(err)
+			slog.ErrorContext(ctx, "cannot check the email", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 			return
 		}
 		//not allowing users with the same email to sign up twice
 		if count > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
+			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+			return
 		}
 
 		//counter of non-unique phone numbers
 		count, err = UserCollection.CountDocuments(ctx, bson.M{"phone": user.Phone})
 		defer cancel()
 		if err != nil {
-			log.Panic(err)
+			slog.ErrorContext(ctx, "cannot check the phone", "error", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 			return
 		}
 
 		//not allowing to sign up with the same phone number twice
 		if count > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone is already in use"})
+			c.JSON(http.StatusConflict, gin.H{"error": "Phone is already in use"})
 			return
 		}
 		//хеширование пароля введенного юзером
-		password := HashPassword(*user.Password)
+		//the password has to follow the password policy
+		if err := passwords.Current.Check(*user.Password, passwordUserInfo(user)...); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		password, err := HashPassword(*user.Password)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot hash the password", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "not created"})
+			return
+		}
 		//назначение паролем уже хешированной версии
 		user.Password = &password
 
@@ -106,29 +144,52 @@ func SignUp() gin.HandlerFunc {
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
 
-		//token generator returns token,refresh token
-		token, refreshtoken, _ := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
-		user.Token = &token
-		user.Refresh_Token = &refreshtoken
+		//no tokens until the email is verified, the user logs in after following the link
+		user.Token = nil
+		user.Refresh_Token = nil
+		user.Email_Verified = false
+		user.Verified_At = nil
+		user.Pending_Email = nil
+		user.TOTP_Enabled = false
+		user.Scopes = nil
+		user.Identities = make([]models.Identity, 0)
 		user.UserCart = make([]models.ProductUser, 0)
 		//make function makes an empty Cart for every user
 
 		user.Address_Details = make([]models.Address, 0)
 		user.Order_Status = make([]models.Order, 0)
+		user.Saved_For_Later = make([]models.ProductUser, 0)
+		user.Wishlists = make([]models.Wishlist, 0)
 		//inserting a single document User into the UserCollection
 		_, inserterr := UserCollection.InsertOne(ctx, user)
+		//the unique indexes catch a sign up that raced past the checks above
+		if database.IsDuplicate(inserterr, database.IndexUserEmail) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+			return
+		}
+		if database.IsDuplicate(inserterr, database.IndexUserPhone) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Phone is already in use"})
+			return
+		}
 		if inserterr != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "not created"})
 			return
 		}
+		metrics.SignedUp("password")
+		//the cart the visitor built before signing up becomes the cart of the new user
+		mergeGuestCart(ctx, c, user.User_ID)
+		//the account is created either way, a failed email can be sent again with /users/resendverification
+		if err := sendVerificationEmail(ctx, user); err != nil {
+			slog.ErrorContext(ctx, "cannot send the verification email", "error", err)
+		}
 		defer cancel()
-		c.JSON(http.StatusCreated, "Successfully Signed Up!!")
+		c.JSON(http.StatusCreated, "Successfully Signed Up!! Check your email to verify the account")
 	}
 }
 
 func Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		var user models.User
 		var founduser models.User
@@ -136,37 +197,102 @@ func Login() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+		*user.Email = database.NormalizeEmail(*user.Email)
+		//the failures are counted for the account and for the address the requests come from
+		accountKey := database.AccountAttemptKey(*user.Email)
+		ipKey := database.IPAttemptKey(c.ClientIP())
+		//a locked login is refused with the same answer as a wrong password, and after as long
+		err := database.CheckLoginAllowed(ctx, LoginAttemptCollection, accountKey, ipKey)
+		if err == database.ErrLoginLocked {
+			verifyDummyPassword(*user.Password)
+			loginFailed(c)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		//checking if that user exists in the db
-		err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
-		defer cancel()
+		err = UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
 
 		//if there is a db function always check for error
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "login or password incorrect"})
+		//an account made by signing in with a provider has no password to log in with
+		if err != nil || founduser.Password == nil {
+			verifyDummyPassword(*user.Password)
+			recordLoginFailure(ctx, accountKey, ipKey)
+			loginFailed(c)
 			return
 		}
 
 		//if u found the right user
 		//check password
-		PasswordIsValid, msg := VerifyPassword(*user.Password, *founduser.Password)
-		defer cancel()
+		PasswordIsValid, _ := VerifyPassword(*user.Password, *founduser.Password)
 		if !PasswordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			fmt.Println(msg)
+			slog.InfoContext(ctx, "wrong password", "user", founduser.User_ID)
+			recordLoginFailure(ctx, accountKey, ipKey)
+			loginFailed(c)
 			return
 		}
-		token, refreshToken, _ := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID)
-		defer cancel()
-		generate.UpdateAllTokens(token, refreshToken, founduser.User_ID)
-		c.JSON(http.StatusFound, founduser)
+		//a hash made with an older policy is replaced while the plain password is at hand
+		if passwords.Current.NeedsRehash(*founduser.Password) {
+			if hash, err := HashPassword(*user.Password); err == nil {
+				_ = database.UpdatePasswordHash(ctx, UserCollection, founduser.User_ID, *founduser.Password, hash)
+			}
+		}
+		//the streak of the account ends with the right password, the one of the address doesn't
+		_, _ = database.ClearLoginFailures(ctx, LoginAttemptCollection, accountKey)
+		//only checked after the password, so it doesn't tell strangers which emails have an account
+		if !founduser.Email_Verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "email is not verified"})
+			return
+		}
+		completeLogin(ctx, c, founduser)
 
 	}
 }
 
+// completeLogin asks for the second factor when the user has one, otherwise the login is done
+func completeLogin(ctx context.Context, c *gin.Context, founduser models.User) {
+	//with two factor authentication the tokens are only given out by /users/login/2fa
+	if founduser.TOTP_Enabled {
+		challenge, err := generate.ChallengeTokenGenerator(founduser.User_ID, founduser.Token_Version)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge": challenge})
+		return
+	}
+	finishLogin(ctx, c, founduser)
+}
+
+// finishLogin gives the tokens to a user that passed every step of the login
+func finishLogin(ctx context.Context, c *gin.Context, founduser models.User) {
+	//every login is its own session, so logging in on a phone leaves the laptop logged in
+	session, err := database.CreateSession(ctx, SessionCollection, founduser.User_ID, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token, refreshToken, err := generate.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID, session.Session_ID.Hex(), founduser.Scopes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	metrics.LoggedIn()
+	mergeGuestCart(ctx, c, founduser.User_ID)
+	//only the profile is shown, never the password hash or the secrets of the user
+	c.JSON(http.StatusOK, gin.H{"user": founduser.Profile(), "token": token, "refresh_token": refreshToken})
+}
+
 // This function lets the Admin to add new products to the list of all products
 func ProductViewerAdmin() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		//creating  a slice name products of type models.Product
 		var products models.Product
 		defer cancel()
@@ -174,6 +300,20 @@ func ProductViewerAdmin() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if products.Price == nil || products.Price.Amount < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "price must be a non negative amount"})
+			return
+		}
+		//no stock means the stock isn't counted for that product
+		if products.Stock != nil && *products.Stock < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stock can't be negative"})
+			return
+		}
+		//the catalog is priced in the base currency, the other currencies are only displayed
+		if products.Price.Currency != models.DefaultCurrency {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "price must be in " + models.DefaultCurrency})
+			return
+		}
 		//assigning the ID of the slice
 		products.Product_ID = primitive.NewObjectID()
 		//inserting a single document 'products' into the collection of products
@@ -192,7 +332,7 @@ func ProductViewerAdmin() gin.HandlerFunc {
 func SearchProduct() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var productlist []models.Product
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
 		//to return all the documents in the collection,
 		//u need to pass an empty query
@@ -207,23 +347,30 @@ func SearchProduct() gin.HandlerFunc {
 		//variable that we created higher
 		err = cursor.All(ctx, &productlist)
 		if err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot read the products", "error", err)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
 		defer cursor.Close(ctx)
 		if err := cursor.Err(); err != nil {
 
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot read the products", "error", err)
 			c.IndentedJSON(400, "invalid")
 			return
 		}
+		if err := displayProducts(ctx, c.Query("currency"), productlist); err != nil {
+			c.IndentedJSON(400, err.Error())
+			return
+		}
 		defer cancel()
 		c.IndentedJSON(200, productlist)
 
 	}
 }
 
+// MaxSearchLength is the longest product name that can be searched for
+const MaxSearchLength = 100
+
 func SearchProductByQuery() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		//searchproducts=allproducts
@@ -231,34 +378,39 @@ func SearchProductByQuery() gin.HandlerFunc {
 		var searchproducts []models.Product
 		queryParam := c.Query("name")
 
-		if queryParam == "" {
+		if queryParam == "" || len(queryParam) > MaxSearchLength {
 			//always log problems in the terminal for urself
-			log.Println("query is empty")
+			slog.DebugContext(c.Request.Context(), "query is empty")
 			c.Header("Content-Type", "application/json")
 			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
 			c.Abort()
 			return
 		}
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
-		//egular expression for string pattern matching
-		searchquerydb, err := ProductCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": queryParam}})
+		//the names that start with the query, the query is quoted so it is matched as plain text
+		//and can't be a slow pattern, the anchor lets mongodb use the index of product_name
+		searchquerydb, err := ProductCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": "^" + regexp.QuoteMeta(queryParam)}})
 		if err != nil {
 			c.IndentedJSON(404, "something went wrong in fetching the dbquery")
 			return
 		}
 		err = searchquerydb.All(ctx, &searchproducts)
 		if err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot read the search results", "error", err)
 			c.IndentedJSON(400, "invalid")
 			return
 		}
 		defer searchquerydb.Close(ctx)
 		if err := searchquerydb.Err(); err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot read the search results", "error", err)
 			c.IndentedJSON(400, "invalid request")
 			return
 		}
+		if err := displayProducts(ctx, c.Query("currency"), searchproducts); err != nil {
+			c.IndentedJSON(400, err.Error())
+			return
+		}
 		defer cancel()
 		c.IndentedJSON(200, searchproducts)
 	}
@@ -268,52 +420,82 @@ func FilterPrice() gin.HandlerFunc{
 		var searchproducts []models.Product
 		queryParam := c.Query("price")
 		if queryParam == "" {
-			log.Println("no price was entered is empty")
+			slog.DebugContext(c.Request.Context(), "no price was entered")
 			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
 			c.Abort()
 			return
 		}
 		filterCond := c.Query("filter")
-		if queryParam == "" {
-			log.Println("choose the filter condition")
+		if filterCond == "" {
+			slog.DebugContext(c.Request.Context(), "no filter condition was chosen")
 			c.JSON(http.StatusNotFound, gin.H{"Error": "Invalid Search Index"})
 			c.Abort()
 			return
 		}
-		var ctx,cancel=context.WithTimeout(context.Background(),100*time.Second)
+		var ctx,cancel=context.WithTimeout(requestContext(c), QueryTimeout)
 		defer cancel()
-		var price_int int
-		price_int,_=strconv.Atoi(queryParam)
-		var searchquerydb *mongo.Cursor
-		var err error
+		//the price is written in major units (12.50) of the currency the user sees the prices in
+		//and it is converted to the base currency the catalog is priced in
+		rate, err := database.GetExchangeRate(ctx, RateCollection, c.Query("currency"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		price, err := models.ParseMoney(queryParam, rate.Currency)
+		if err == nil {
+			price, err = rate.ToBase(price)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"Error": err.Error()})
+			return
+		}
+		var operator string
 		switch filterCond{
 		case "eq":
-				searchquerydb, err = ProductCollection.Find(ctx, bson.M{"price": bson.M{"$eq": price_int}})
-
+			operator = "$eq"
 		case "gte":
-				searchquerydb, err = ProductCollection.Find(ctx, bson.M{"price": bson.M{"$gte": price_int}})
-				
+			operator = "$gte"
 		case "lte":
-				searchquerydb, err = ProductCollection.Find(ctx, bson.M{"price": bson.M{"$lte": price_int}})
-		
+			operator = "$lte"
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"Error": "filter must be eq, gte or lte"})
+			return
 		}
+		searchquerydb, err := ProductCollection.Find(ctx, bson.M{"price.currency": price.Currency, "price.amount": bson.M{operator: price.Amount}})
 		if err != nil {
 			c.IndentedJSON(404, "something went wrong in fetching the dbquery")
 			return
 		}
 		err = searchquerydb.All(ctx, &searchproducts)
 		if err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot read the filtered products", "error", err)
 			c.IndentedJSON(400, "invalid")
 			return
 		}
 		defer searchquerydb.Close(ctx)
 		if err := searchquerydb.Err(); err != nil {
-			log.Println(err)
+			slog.ErrorContext(ctx, "cannot read the filtered products", "error", err)
 			c.IndentedJSON(400, "invalid request")
 			return
 		}
+		if err := displayProducts(ctx, c.Query("currency"), searchproducts); err != nil {
+			c.IndentedJSON(400, err.Error())
+			return
+		}
 		defer cancel()
 		c.IndentedJSON(200, searchproducts)
 	}
 }
+
+// displayProducts converts the prices into the currency the user asked for with ?currency=
+func displayProducts(ctx context.Context, currency string, products []models.Product) error {
+	rate, err := database.GetExchangeRate(ctx, RateCollection, currency)
+	if err != nil {
+		return err
+	}
+	if err := database.DisplayProducts(rate, products); err != nil {
+		slog.WarnContext(ctx, "cannot convert the prices of the products", "error", err)
+		return database.ErrCantConvertPrices
+	}
+	return nil
+}
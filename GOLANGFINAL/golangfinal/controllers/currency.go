@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the admin keeps the exchange rate table, the customers pick the currency the prices are shown in
+
+// SetExchangeRate adds the rate of a currency or replaces the one that is already there
+func SetExchangeRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rate models.ExchangeRate
+		if err := c.BindJSON(&rate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if rate.Rounding.Mode == "" {
+			rate.Rounding = models.BaseRate().Rounding
+		}
+		if err := rate.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if rate.Currency == models.DefaultCurrency {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the base currency has no exchange rate"})
+			return
+		}
+		rate.Updated_At = time.Now()
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		_, err := RateCollection.ReplaceOne(ctx, bson.M{"_id": rate.Currency}, rate, options.Replace().SetUpsert(true))
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot save the exchange rate", "error", err)
+			c.IndentedJSON(500, "Something Went Wrong")
+			return
+		}
+		c.IndentedJSON(200, rate)
+	}
+}
+
+// ListExchangeRates is public so the customers know which currencies they can choose
+func ListExchangeRates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		rates, err := database.GetExchangeRates(ctx, RateCollection)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.IndentedJSON(200, rates)
+	}
+}
+
+func DeleteExchangeRate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		currency := c.Query("currency")
+		if currency == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "currency is empty"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		result, err := RateCollection.DeleteOne(ctx, bson.M{"_id": currency})
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot delete the exchange rate", "error", err)
+			c.IndentedJSON(500, "Something Went Wrong")
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUnknownCurrency.Error()})
+			return
+		}
+		c.IndentedJSON(200, "Successfully Deleted!")
+	}
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"golangfinal/database"
+	"golangfinal/metrics"
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+/*
+visitors can build a cart without an account.
+the cart is identified by a signed cart token that is sent back in the X-Cart-Token header,
+when the visitor logs in or signs up with that header the cart is merged into the user cart
+*/
+const CartTokenHeader = "X-Cart-Token"
+
+func GuestAddToCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is invalid"))
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		cartID := ""
+		if token := c.GetHeader(CartTokenHeader); token != "" {
+			cartID, err = generate.ValidateGuestCartToken(token)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		//the first product creates the cart and its token
+		if cartID == "" {
+			cart, err := database.CreateGuestCart(ctx, GuestCartCollection)
+			if err != nil {
+				c.IndentedJSON(http.StatusInternalServerError, err.Error())
+				return
+			}
+			cartID = cart.Cart_ID.Hex()
+		}
+		err = database.AddProductToGuestCart(ctx, ProductCollection, GuestCartCollection, productID, cartID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrCantFindProduct || err == database.ErrCantFindGuestCart {
+				status = http.StatusNotFound
+			}
+			c.IndentedJSON(status, err.Error())
+			return
+		}
+		metrics.AddedToCart("guest")
+		token, err := generate.GuestCartTokenGenerator(cartID)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot create the guest cart token", "error", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Header(CartTokenHeader, token)
+		c.IndentedJSON(200, gin.H{"cart_token": token, "message": "Successfully Added to the cart"})
+	}
+}
+
+func GuestRemoveItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is invalid"))
+			return
+		}
+		cartID, err := generate.ValidateGuestCartToken(c.GetHeader(CartTokenHeader))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err = database.RemoveGuestCartItem(ctx, GuestCartCollection, productID, cartID)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+		c.IndentedJSON(200, "Successfully removed from cart")
+	}
+}
+
+// GuestListCart shows the cart like GetItemFromCart, a visitor has no address so only
+// the tax rules that don't depend on the region apply
+func GuestListCart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cartID, err := generate.ValidateGuestCartToken(c.GetHeader(CartTokenHeader))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		cart, err := database.GetGuestCart(ctx, GuestCartCollection, cartID)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+		rules, err := database.GetTaxRules(ctx, TaxRuleCollection)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		taxlines, totaltax, totalprice, err := database.CalculateTax(rules, nil, cart.Items)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot calculate the tax of the guest cart", "error", err)
+			c.IndentedJSON(http.StatusConflict, err.Error())
+			return
+		}
+		rate, err := database.GetExchangeRate(ctx, RateCollection, c.Query("currency"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, err.Error())
+			return
+		}
+		display, err := rate.Applied(totalprice)
+		if err == nil {
+			err = database.DisplayCart(rate, cart.Items)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "cannot convert the prices of the guest cart", "error", err)
+			c.IndentedJSON(http.StatusConflict, database.ErrCantConvertPrices.Error())
+			return
+		}
+		c.IndentedJSON(200, gin.H{
+			"total":         totalprice,
+			"total_tax":     totaltax,
+			"usercart":      cart.Items,
+			"tax_lines":     taxlines,
+			"exchange_rate": display,
+		})
+	}
+}
+
+// mergeGuestCart merges the cart of the X-Cart-Token header into the cart of the user,
+// a missing or expired token is not an error, the user just has nothing to merge
+func mergeGuestCart(ctx context.Context, c *gin.Context, userID string) {
+	token := c.GetHeader(CartTokenHeader)
+	if token == "" {
+		return
+	}
+	cartID, err := generate.ValidateGuestCartToken(token)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid guest cart token, the cart is not merged", "error", err)
+		return
+	}
+	err = database.MergeGuestCart(ctx, UserCollection, GuestCartCollection, cartID, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "cannot merge the guest cart", "error", err)
+	}
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/version"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// ReadinessTimeout is how long /readyz waits for the database, main sets it from the config
+var ReadinessTimeout = 2 * time.Second
+
+var (
+	//set when the server starts stopping, from then on it isn't ready anymore
+	shuttingDown atomic.Bool
+	//migrations are never undone, once they all ran the check is skipped
+	migrated atomic.Bool
+)
+
+// ShuttingDown makes /readyz answer 503, so no new requests are sent while the server stops
+func ShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Healthz answers as long as the process runs, GET /healthz
+func Healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+/*
+Readyz tells if the server can take requests, GET /readyz. it can when the database answers
+within the readiness timeout and every migration ran, and not anymore once it is shutting down
+*/
+func Readyz(db *mongo.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if shuttingDown.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), ReadinessTimeout)
+		defer cancel()
+		if err := db.Client().Ping(ctx, readpref.Primary()); err != nil {
+			slog.WarnContext(ctx, "not ready, the database is unreachable", "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database unreachable"})
+			return
+		}
+		if !migrated.Load() {
+			pending, err := database.PendingMigrations(ctx, db)
+			if err != nil {
+				slog.WarnContext(ctx, "not ready, cannot read the migrations", "error", err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database unreachable"})
+				return
+			}
+			if len(pending) > 0 {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"status": fmt.Sprintf("%d migrations pending, from version %d", len(pending), pending[0].Version)})
+				return
+			}
+			migrated.Store(true)
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	}
+}
+
+// Version tells which build is running, GET /version
+func Version() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, version.Get())
+	}
+}
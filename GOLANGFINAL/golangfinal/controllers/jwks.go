@@ -0,0 +1,18 @@
+package controllers
+
+import (
+	"net/http"
+
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys of the tokens, GET /.well-known/jwks.json
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		//short enough that a rotated key shows up soon in the other services
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, generate.Keys.JWKS())
+	}
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"golangfinal/database"
+	"golangfinal/metrics"
+	"golangfinal/models"
+	"golangfinal/passwords"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var LoginAttemptCollection *mongo.Collection
+
+// loginFailed is the one answer for an unknown email, a wrong password and a locked login
+func loginFailed(c *gin.Context) {
+	metrics.LoginFailed()
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "login or password incorrect"})
+}
+
+// dummyHash is a hash of the current policy that no password matches, made the first time it is needed
+var dummyHash struct {
+	sync.Mutex
+	policy *passwords.Policy
+	hash   string
+}
+
+/*
+verifyDummyPassword checks the password against a hash no account has. an unknown email and a
+locked login take as long as a wrong password this way, the time of the answer doesn't tell them apart
+*/
+func verifyDummyPassword(password string) {
+	dummyHash.Lock()
+	if dummyHash.policy != passwords.Current {
+		hash, err := passwords.Current.Hash("no account has this password")
+		if err != nil {
+			dummyHash.Unlock()
+			slog.Error("cannot make the dummy password hash", "error", err)
+			return
+		}
+		dummyHash.policy, dummyHash.hash = passwords.Current, hash
+	}
+	hash := dummyHash.hash
+	dummyHash.Unlock()
+	_, _ = passwords.Current.Verify(password, hash)
+}
+
+func recordLoginFailure(ctx context.Context, accountKey string, ipKey string) {
+	if err := database.RecordLoginFailure(ctx, LoginAttemptCollection, accountKey, database.AccountFailureLimit); err != nil {
+		slog.ErrorContext(ctx, "cannot record the failed login of the account", "error", err)
+	}
+	if err := database.RecordLoginFailure(ctx, LoginAttemptCollection, ipKey, database.IPFailureLimit); err != nil {
+		slog.ErrorContext(ctx, "cannot record the failed login of the address", "error", err)
+	}
+}
+
+/*
+confirmUser checks the password a logged in user gives again to change the password or the email,
+turn off two factor authentication or delete the account, and with code not nil the code of the app
+too. the failures count and lock like failed logins, or a stolen session could guess the password
+without limit. it answers the request and returns false when it can't go on
+*/
+func confirmUser(ctx context.Context, c *gin.Context, user models.User, password string, code *string) bool {
+	keys := []string{database.IPAttemptKey(c.ClientIP())}
+	if user.Email != nil {
+		keys = append(keys, database.AccountAttemptKey(*user.Email))
+	}
+	err := database.CheckLoginAllowed(ctx, LoginAttemptCollection, keys...)
+	if err == database.ErrLoginLocked {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	valid, msg := true, ""
+	//accounts made through a login provider have no password, the session is enough for them
+	if user.Password != nil {
+		valid, msg = VerifyPassword(password, *user.Password)
+	}
+	if valid && code != nil {
+		if err := checkSecondFactor(ctx, user, *code); err != nil {
+			valid, msg = false, err.Error()
+		}
+	}
+	if !valid {
+		if user.Email != nil {
+			recordLoginFailure(ctx, keys[1], keys[0])
+		} else if err := database.RecordLoginFailure(ctx, LoginAttemptCollection, keys[0], database.IPFailureLimit); err != nil {
+			slog.ErrorContext(ctx, "cannot record the failed login of the address", "error", err)
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": msg})
+		return false
+	}
+	if user.Email != nil {
+		_, _ = database.ClearLoginFailures(ctx, LoginAttemptCollection, keys[1])
+	}
+	return true
+}
+
+// UnlockAccount lets an admin clear the failed logins of an account, DELETE /admin/unlockaccount?email=
+func UnlockAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email := c.Query("email")
+		if email == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email is empty"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		cleared, err := database.ClearLoginFailures(ctx, LoginAttemptCollection, database.AccountAttemptKey(email))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !cleared {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the account has no failed logins"})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully unlocked the account")
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	m.Run()
+}
+
+/*
+useMockDatabase points every collection to the mock client of mt, the answers of the
+database are queued with mt.AddMockResponses in the order the handler makes its calls
+*/
+func useMockDatabase(mt *mtest.T) {
+	mt.Helper()
+	UseDatabase(mt.Client)
+	keys, err := generate.LoadKeys("a secret only for the tests", "", "")
+	if err != nil {
+		mt.Fatal(err)
+	}
+	generate.Keys = keys
+}
+
+// document is v as the database returns it
+func document(mt *mtest.T, v interface{}) bson.D {
+	mt.Helper()
+	data, err := bson.Marshal(v)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		mt.Fatal(err)
+	}
+	return doc
+}
+
+// noDocument is the answer to a FindOne that matches nothing
+func noDocument(namespace string) bson.D {
+	return mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch)
+}
+
+// commands are the names of the commands the handler sent to the database, like "find" or "update"
+func commands(mt *mtest.T) []string {
+	var names []string
+	for _, event := range mt.GetAllStartedEvents() {
+		names = append(names, event.CommandName)
+	}
+	return names
+}
+
+func serve(router *gin.Engine, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
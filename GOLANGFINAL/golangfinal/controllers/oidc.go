@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/metrics"
+	"golangfinal/models"
+	"golangfinal/oidclogin"
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OIDCProviders are the providers users can sign in with, main loads them from the environment
+var OIDCProviders = map[string]*oidclogin.Provider{}
+
+// the cookie that keeps the state, the nonce and the PKCE verifier between the redirect and the callback
+const loginStateCookie = "oidc_login"
+
+// ListOIDCProviders lists the names of the providers, GET /users/oidc/providers
+func ListOIDCProviders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		names := make([]string, 0, len(OIDCProviders))
+		for name := range OIDCProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.JSON(http.StatusOK, names)
+	}
+}
+
+// OIDCLogin sends the user to the provider to sign in, GET /users/oidc/:provider/login
+func OIDCLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("provider")
+		provider, found := OIDCProviders[name]
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": oidclogin.ErrUnknownProvider.Error()})
+			return
+		}
+		state, err1 := randomString()
+		nonce, err2 := randomString()
+		verifier, err3 := randomString()
+		if err1 != nil || err2 != nil || err3 != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot start the login"})
+			return
+		}
+		cookie, err := generate.LoginStateTokenGenerator(name, state, nonce, verifier)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(loginStateCookie, cookie, 600, "/users/oidc/"+name, "", strings.HasPrefix(AppBaseURL, "https://"), true)
+		c.Redirect(http.StatusFound, provider.AuthURL(state, nonce, verifier))
+	}
+}
+
+/*
+OIDCCallback finishes the sign in, GET /users/oidc/:provider/callback.
+a known account at the provider logs its user in, otherwise the account is linked to the user
+with the same email, or a new user is made. only emails the provider has verified are used
+*/
+func OIDCCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("provider")
+		provider, found := OIDCProviders[name]
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": oidclogin.ErrUnknownProvider.Error()})
+			return
+		}
+		if providerErr := c.Query("error"); providerErr != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": providerErr, "error_description": c.Query("error_description")})
+			return
+		}
+		cookie, err := c.Cookie(loginStateCookie)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the login was not started here or took too long"})
+			return
+		}
+		//the cookie is only good once
+		c.SetCookie(loginStateCookie, "", -1, "/users/oidc/"+name, "", strings.HasPrefix(AppBaseURL, "https://"), true)
+		loginState, err := generate.ValidateLoginStateToken(cookie)
+		if err != nil || loginState.Provider != name || subtle.ConstantTimeCompare([]byte(loginState.State), []byte(c.Query("state"))) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the state of the login does not match"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), LongQueryTimeout)
+		defer cancel()
+		identity, err := provider.Exchange(ctx, c.Query("code"), loginState.Verifier, loginState.Nonce)
+		if err != nil {
+			slog.WarnContext(ctx, "the login provider did not confirm the login", "provider", name, "error", err)
+			metrics.LoginFailed()
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the provider did not confirm the login"})
+			return
+		}
+
+		founduser, err := database.FindUserByIdentity(ctx, UserCollection, identity.Provider, identity.Subject)
+		if err == nil {
+			completeLogin(ctx, c, founduser)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if identity.Email == "" || !identity.Email_Verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "the provider did not confirm the email address"})
+			return
+		}
+		//the provider may not keep the case the user signed up with
+		identity.Email = database.NormalizeEmail(identity.Email)
+		link := models.Identity{Provider: identity.Provider, Subject: identity.Subject, Email: identity.Email, Linked_At: time.Now()}
+		err = UserCollection.FindOne(ctx, bson.M{"email": identity.Email}).Decode(&founduser)
+		if err == nil {
+			if err = database.LinkIdentity(ctx, UserCollection, founduser.User_ID, link, !founduser.Email_Verified); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if !founduser.Email_Verified {
+				//whoever made the account can't use it anymore
+				_ = database.RevokeSessions(ctx, SessionCollection, founduser.User_ID, "")
+			}
+			completeLogin(ctx, c, founduser)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		user, err := newOIDCUser(ctx, identity, link)
+		if database.IsDuplicate(err, database.IndexUserEmail) || database.IsDuplicate(err, database.IndexUserIdentity) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the account was created at the same time, log in again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "not created"})
+			return
+		}
+		completeLogin(ctx, c, user)
+	}
+}
+
+// newOIDCUser signs up the user of a provider account, the user has no password and no phone yet
+func newOIDCUser(ctx context.Context, identity oidclogin.Identity, link models.Identity) (models.User, error) {
+	var user models.User
+	firstName, lastName := identity.First_Name, identity.Last_Name
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(identity.Email, "@")
+	}
+	email := identity.Email
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.ID = primitive.NewObjectID()
+	user.User_ID = user.ID.Hex()
+	user.First_Name = &firstName
+	user.Last_Name = &lastName
+	user.Email = &email
+	user.Created_At = now
+	user.Updated_At = now
+	user.Email_Verified = true
+	user.Verified_At = &now
+	user.UserCart = make([]models.ProductUser, 0)
+	user.Address_Details = make([]models.Address, 0)
+	user.Order_Status = make([]models.Order, 0)
+	user.Saved_For_Later = make([]models.ProductUser, 0)
+	user.Wishlists = make([]models.Wishlist, 0)
+	user.Identities = []models.Identity{link}
+	if _, err := UserCollection.InsertOne(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "cannot create the user of the login", "error", err)
+		return user, err
+	}
+	metrics.SignedUp("oidc")
+	return user, nil
+}
+
+func randomString() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golangfinal/models"
+	"golangfinal/oidclogin"
+	"golangfinal/oidclogin/oidctest"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// oidcRouter serves the login of a provider "test" at the fake issuer
+func oidcRouter(mt *mtest.T) (*oidctest.Issuer, *gin.Engine) {
+	mt.Helper()
+	issuer, err := oidctest.NewIssuer("shop", "shop-secret")
+	if err != nil {
+		mt.Fatal(err)
+	}
+	mt.Cleanup(issuer.Close)
+	provider, err := oidclogin.NewProvider(context.Background(), "test", issuer.URL, "shop", "shop-secret",
+		"http://shop.example/users/oidc/test/callback", []string{"openid", "email", "profile"}, issuer.Client())
+	if err != nil {
+		mt.Fatal(err)
+	}
+	saved := OIDCProviders
+	OIDCProviders = map[string]*oidclogin.Provider{"test": provider}
+	mt.Cleanup(func() { OIDCProviders = saved })
+	router := gin.New()
+	router.GET("/users/oidc/:provider/login", OIDCLogin())
+	router.GET("/users/oidc/:provider/callback", OIDCCallback())
+	return issuer, router
+}
+
+/*
+startLogin signs the user in at the provider like a browser would:
+it follows the redirect of /login and returns the query of the callback and the state cookie
+*/
+func startLogin(mt *mtest.T, issuer *oidctest.Issuer, router *gin.Engine, user map[string]interface{}) (url.Values, *http.Cookie) {
+	mt.Helper()
+	response := serve(router, httptest.NewRequest(http.MethodGet, "/users/oidc/test/login", nil))
+	if response.Code != http.StatusFound {
+		mt.Fatalf("login = %d %s, want a redirect", response.Code, response.Body)
+	}
+	cookies := response.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != loginStateCookie || !cookies[0].HttpOnly {
+		mt.Fatalf("cookies = %v, want the http only %s", cookies, loginStateCookie)
+	}
+	issuer.SetUser(user)
+	callback, err := issuer.SignIn(response.Header().Get("Location"))
+	if err != nil {
+		mt.Fatal(err)
+	}
+	return callback, cookies[0]
+}
+
+func callback(router *gin.Engine, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, "/users/oidc/test/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	return serve(router, request)
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("state mismatch", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		issuer, router := oidcRouter(mt)
+		query, cookie := startLogin(mt, issuer, router, map[string]interface{}{"sub": "1234"})
+		query.Set("state", "state-of-another-login")
+		response := callback(router, query, cookie)
+		if response.Code != http.StatusBadRequest {
+			mt.Errorf("callback = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+		//the code is never exchanged and nothing is looked up
+		if verifiers := issuer.Verifiers(); len(verifiers) != 0 {
+			mt.Errorf("the code was exchanged with %q", verifiers)
+		}
+		if sent := commands(mt); len(sent) != 0 {
+			mt.Errorf("the database got %v", sent)
+		}
+	})
+
+	mt.Run("no cookie", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		issuer, router := oidcRouter(mt)
+		query, _ := startLogin(mt, issuer, router, map[string]interface{}{"sub": "1234"})
+		if response := callback(router, query, nil); response.Code != http.StatusBadRequest {
+			mt.Errorf("callback = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+		if verifiers := issuer.Verifiers(); len(verifiers) != 0 {
+			mt.Errorf("the code was exchanged with %q", verifiers)
+		}
+	})
+
+	mt.Run("cookie of another provider", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		issuer, router := oidcRouter(mt)
+		query, cookie := startLogin(mt, issuer, router, map[string]interface{}{"sub": "1234"})
+		OIDCProviders["other"] = OIDCProviders["test"]
+		request := httptest.NewRequest(http.MethodGet, "/users/oidc/other/callback?"+query.Encode(), nil)
+		request.AddCookie(cookie)
+		if response := serve(router, request); response.Code != http.StatusBadRequest {
+			mt.Errorf("callback = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestOIDCCallbackLinking(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("unverified email is not linked", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		issuer, router := oidcRouter(mt)
+		query, cookie := startLogin(mt, issuer, router, map[string]interface{}{
+			"sub": "1234", "email": "ann@example.com", "email_verified": false,
+		})
+		//no account is linked to the provider account yet
+		mt.AddMockResponses(noDocument("shop.Users"))
+		response := callback(router, query, cookie)
+		if response.Code != http.StatusForbidden {
+			mt.Errorf("callback = %d %s, want %d", response.Code, response.Body, http.StatusForbidden)
+		}
+		//only the look up of the provider account, the user with the email is never touched
+		if sent := commands(mt); len(sent) != 1 || sent[0] != "find" {
+			mt.Errorf("the database got %v, want a single find", sent)
+		}
+	})
+
+	mt.Run("verified email is linked", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		issuer, router := oidcRouter(mt)
+		query, cookie := startLogin(mt, issuer, router, map[string]interface{}{
+			"sub": "1234", "email": "Ann@Example.com", "email_verified": true,
+		})
+		first, last, email := "Ann", "Smith", "ann@example.com"
+		id := primitive.NewObjectID()
+		user := models.User{ID: id, User_ID: id.Hex(), First_Name: &first, Last_Name: &last, Email: &email, Email_Verified: false}
+		mt.AddMockResponses(
+			noDocument("shop.Users"),
+			mtest.CreateCursorResponse(0, "shop.Users", mtest.FirstBatch, document(mt, user)),
+			//the identity is pushed
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			//the sessions of whoever signed up with the address are revoked
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+			//the session of this login
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		response := callback(router, query, cookie)
+		if response.Code != http.StatusOK {
+			mt.Fatalf("callback = %d %s, want %d", response.Code, response.Body, http.StatusOK)
+		}
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil || body.Token == "" {
+			mt.Errorf("body = %s, want the tokens of the user", response.Body)
+		}
+		//the verifier the login kept in the cookie reached the token endpoint and matched the challenge
+		if verifiers := issuer.Verifiers(); len(verifiers) != 1 {
+			mt.Errorf("the token endpoint got %q, want one verifier", verifiers)
+		}
+		want := []string{"find", "find", "update", "update", "insert"}
+		if sent := commands(mt); strings.Join(sent, ",") != strings.Join(want, ",") {
+			mt.Fatalf("the database got %v, want %v", sent, want)
+		}
+		events := mt.GetAllStartedEvents()
+		//the user is found by the email in lower case
+		if filter := events[1].Command.Lookup("filter", "email").StringValue(); filter != "ann@example.com" {
+			mt.Errorf("the user is looked up by %q", filter)
+		}
+		link := events[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if subject := link.Lookup("u", "$push", "identities", "subject").StringValue(); subject != "1234" {
+			mt.Errorf("update = %s, want the identity 1234 pushed", link)
+		}
+		if verified := link.Lookup("u", "$set", "email_verified"); !verified.Boolean() {
+			mt.Errorf("update = %s, want the email verified by the provider", link)
+		}
+	})
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"golangfinal/database"
+	"golangfinal/mailer"
+	"golangfinal/models"
+	"golangfinal/passwords"
+	generate "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var ErrNoPassword = errors.New("the account has no password, set one with forgot password")
+
+// passwordUserInfo is what a password of the user must not be
+func passwordUserInfo(user models.User) []string {
+	info := make([]string, 0, 4)
+	for _, field := range []*string{user.Email, user.First_Name, user.Last_Name, user.Phone} {
+		if field != nil {
+			info = append(info, *field)
+		}
+	}
+	return info
+}
+
+/*
+ForgotPassword emails a password reset link, POST /users/forgotpassword {"email": ...}.
+like ResendVerification it answers the same for unknown addresses, and when too many
+emails were sent it answers the same too without sending one
+*/
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"email,required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		const sent = "If the account exists, an email with a reset link was sent"
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"email": database.NormalizeEmail(body.Email)}).Decode(&user); err != nil {
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+		err := database.CheckEmailRate(ctx, VerificationCollection, user.User_ID, database.PurposeResetPassword)
+		//a 429 would tell that the address has an account
+		if err == database.ErrTooManyEmails {
+			slog.InfoContext(ctx, "reset email not sent, too many were sent", "user_id", user.User_ID)
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+		if err == nil {
+			err = sendResetEmail(ctx, user)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot send the reset email", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot send the email"})
+			return
+		}
+		c.JSON(http.StatusOK, sent)
+	}
+}
+
+func sendResetEmail(ctx context.Context, user models.User) error {
+	token, err := database.CreateVerificationToken(ctx, VerificationCollection, user.User_ID, database.PurposeResetPassword, *user.Email, database.ResetTokenLifetime)
+	if err != nil {
+		return err
+	}
+	link := AppBaseURL + "/users/resetpassword?token=" + url.QueryEscape(token)
+	return Mailer.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your account. to choose a new one open this link:\n%s\n\nthe link works once and expires in %s. if it wasn't you, ignore this email.\n", *user.First_Name, link, database.ResetTokenLifetime),
+	})
+}
+
+// resetPasswordPage is the form behind the link of the reset email, it posts the token and the new password
+var resetPasswordPage = template.Must(template.New("resetpassword").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset your password</title></head>
+<body>
+<h1>Reset your password</h1>
+<form method="post" action="/users/resetpassword">
+<input type="hidden" name="token" value="{{.}}">
+<label>New password <input type="password" name="password" autocomplete="new-password" required></label>
+<button type="submit">Set the password</button>
+</form>
+</body>
+</html>
+`))
+
+/*
+ResetPasswordForm is the link from the reset email, GET /users/resetpassword?token=.
+it only shows the form, the token is checked and spent when the form is posted
+*/
+func ResetPasswordForm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is empty"})
+			return
+		}
+		//the token is in the address of the page, it must not be cached or sent on as referer
+		c.Header("Cache-Control", "no-store")
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := resetPasswordPage.Execute(c.Writer, token); err != nil {
+			slog.ErrorContext(requestContext(c), "cannot show the reset form", "error", err)
+		}
+	}
+}
+
+/*
+ResetPassword sets a new password with the token from the reset email, POST /users/resetpassword
+with {"token": ..., "password": ...} or the form of ResetPasswordForm.
+the token is only spent once the new password passed the policy, a rejected password can be
+tried again with the same link
+*/
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token    string `json:"token" form:"token" validate:"required"`
+			Password string `json:"password" form:"password" validate:"required"`
+		}
+		//json from the api, url encoded from the form
+		if err := c.Bind(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		record, err := database.FindVerificationToken(ctx, VerificationCollection, body.Token, database.PurposeResetPassword)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		//the link only works for the address it was sent to
+		var user models.User
+		err = UserCollection.FindOne(ctx, bson.M{"user_id": record.User_ID, "email": record.Email}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrInvalidVerificationToken.Error()})
+			return
+		}
+		if err = passwords.Current.Check(body.Password, passwordUserInfo(user)...); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := HashPassword(body.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		//two requests with the same link can both get here, only the one that uses the token sets the password
+		if _, err = database.UseVerificationToken(ctx, VerificationCollection, body.Token, database.PurposeResetPassword); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		_, err = database.SetPassword(ctx, UserCollection, user.User_ID, hash)
+		//whoever knew the old password is logged out everywhere
+		if err == nil {
+			err = database.RevokeSessions(ctx, SessionCollection, user.User_ID, "")
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Password changed, you can log in with the new password")
+	}
+}
+
+/*
+ChangePassword changes the password of the logged in user, POST /users/changepassword.
+the old password has to be given again. every other session is logged out,
+the caller gets new tokens for its session back
+*/
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Old_Password string `json:"old_password" validate:"required"`
+			New_Password string `json:"new_password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		//an account made by signing in with a provider has no password until it is reset
+		if user.Password == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrNoPassword.Error()})
+			return
+		}
+		if !confirmUser(ctx, c, user, body.Old_Password, nil) {
+			return
+		}
+		if err := passwords.Current.Check(body.New_Password, passwordUserInfo(user)...); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		hash, err := HashPassword(body.New_Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		_, err = database.SetPassword(ctx, UserCollection, user.User_ID, hash)
+		//the session that changed the password stays logged in
+		if err == nil {
+			err = database.RevokeSessions(ctx, SessionCollection, user.User_ID, c.GetString("sid"))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		token, refreshToken, err := generate.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, c.GetString("sid"), user.Scopes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+	}
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golangfinal/database"
+	"golangfinal/models"
+	"golangfinal/passwords"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+var resetLink = regexp.MustCompile(`(/users/resetpassword\?token=)(\S+)`)
+
+func passwordRouter() *gin.Engine {
+	router := gin.New()
+	router.POST("/users/forgotpassword", ForgotPassword())
+	router.GET("/users/resetpassword", ResetPasswordForm())
+	router.POST("/users/resetpassword", ResetPassword())
+	return router
+}
+
+// useFastPolicy hashes with the lowest bcrypt cost, the tests don't need slow hashes
+func useFastPolicy(mt *mtest.T) {
+	policy := passwords.Default()
+	policy.BcryptCost = 4
+	saved := passwords.Current
+	passwords.Current = policy
+	mt.Cleanup(func() { passwords.Current = saved })
+}
+
+func TestResetPasswordLink(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("the emailed link opens a form that resets the password", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		useFastPolicy(mt)
+		outbox := useOutbox(mt)
+		router := passwordRouter()
+		user := testUser(true)
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+			emailsSent(0),
+			noDocument(tokensNS),
+			mtest.CreateSuccessResponse(),
+		)
+		request := httptest.NewRequest(http.MethodPost, "/users/forgotpassword", strings.NewReader(`{"email": "ann@example.com"}`))
+		request.Header.Set("Content-Type", "application/json")
+		if response := serve(router, request); response.Code != http.StatusOK {
+			mt.Fatalf("forgotpassword = %d %s", response.Code, response.Body)
+		}
+		message, found := outbox.Last("ann@example.com")
+		if !found {
+			mt.Fatal("no reset email was sent")
+		}
+		match := resetLink.FindStringSubmatch(message.Body)
+		if match == nil || !strings.Contains(message.Body, AppBaseURL+match[0]) {
+			mt.Fatalf("the email has no reset link: %s", message.Body)
+		}
+		token, err := url.QueryUnescape(match[2])
+		if err != nil {
+			mt.Fatal(err)
+		}
+
+		//following the link is a GET, like a click in the mail client
+		mt.ClearEvents()
+		page := serve(router, httptest.NewRequest(http.MethodGet, match[0], nil))
+		if page.Code != http.StatusOK || !strings.HasPrefix(page.Header().Get("Content-Type"), "text/html") {
+			mt.Fatalf("GET %s = %d %s %s, want an html page", match[0], page.Code, page.Header().Get("Content-Type"), page.Body)
+		}
+		if page.Header().Get("Referrer-Policy") != "no-referrer" || page.Header().Get("Cache-Control") != "no-store" {
+			mt.Errorf("the page with the token may be cached or sent as referer: %v", page.Header())
+		}
+		body := page.Body.String()
+		if !strings.Contains(body, `action="/users/resetpassword"`) || !strings.Contains(body, `method="post"`) || !strings.Contains(body, `value="`+token+`"`) {
+			mt.Fatalf("the page has no form that posts the token: %s", body)
+		}
+		if sent := commands(mt); len(sent) != 0 {
+			mt.Errorf("showing the form sent %v to the database, the token must not be spent", sent)
+		}
+
+		//the form posts the token and the new password url encoded
+		record := models.VerificationToken{Token_ID: primitive.NewObjectID(), User_ID: user.User_ID, Purpose: database.PurposeResetPassword, Email: *user.Email}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, tokensNS, mtest.FirstBatch, document(mt, record)),
+			mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: document(mt, record)}),
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "token_version", Value: 1}}}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		form := url.Values{"token": {token}, "password": {"a new long password"}}
+		post := httptest.NewRequest(http.MethodPost, "/users/resetpassword", strings.NewReader(form.Encode()))
+		post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if response := serve(router, post); response.Code != http.StatusOK {
+			mt.Fatalf("posting the form = %d %s, want %d", response.Code, response.Body, http.StatusOK)
+		}
+		want := []string{"find", "find", "findAndModify", "findAndModify", "update"}
+		if sent := commands(mt); strings.Join(sent, ",") != strings.Join(want, ",") {
+			mt.Errorf("the database got %v, want %v", sent, want)
+		}
+	})
+
+	mt.Run("no token", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		response := serve(passwordRouter(), httptest.NewRequest(http.MethodGet, "/users/resetpassword", nil))
+		if response.Code != http.StatusBadRequest {
+			mt.Errorf("GET without a token = %d, want %d", response.Code, http.StatusBadRequest)
+		}
+	})
+
+	mt.Run("the token is escaped in the page", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		response := serve(passwordRouter(), httptest.NewRequest(http.MethodGet, "/users/resetpassword?token="+url.QueryEscape(`"><script>`), nil))
+		if strings.Contains(response.Body.String(), "<script>") {
+			mt.Errorf("the token was put into the page as html: %s", response.Body)
+		}
+	})
+}
+
+func TestChangePasswordAttempts(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	const attemptsNS = "shop.LoginAttempts"
+	change := func(mt *mtest.T, user models.User) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("uid", user.User_ID) })
+		router.POST("/users/changepassword", ChangePassword())
+		request := httptest.NewRequest(http.MethodPost, "/users/changepassword",
+			strings.NewReader(`{"old_password": "a guess", "new_password": "a new long password"}`))
+		request.Header.Set("Content-Type", "application/json")
+		return serve(router, request)
+	}
+	userWithPassword := func(mt *mtest.T) models.User {
+		user := testUser(true)
+		hash, err := HashPassword("the right password")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		user.Password = &hash
+		return user
+	}
+
+	mt.Run("a wrong password counts as a failed login", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		useFastPolicy(mt)
+		user := userWithPassword(mt)
+		failure := mtest.CreateSuccessResponse(bson.E{Key: "value", Value: bson.D{{Key: "failures", Value: 1}}})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+			mtest.CreateCursorResponse(0, attemptsNS, mtest.FirstBatch),
+			failure, failure,
+		)
+		if response := change(mt, user); response.Code != http.StatusForbidden {
+			mt.Fatalf("changepassword = %d %s, want %d", response.Code, response.Body, http.StatusForbidden)
+		}
+		want := []string{"find", "aggregate", "findAndModify", "findAndModify"}
+		if sent := commands(mt); strings.Join(sent, ",") != strings.Join(want, ",") {
+			mt.Fatalf("the database got %v, want %v", sent, want)
+		}
+		events := mt.GetAllStartedEvents()
+		if key := events[2].Command.Lookup("query", "_id").StringValue(); key != "account:ann@example.com" {
+			mt.Errorf("the failure was counted for %q, want the account", key)
+		}
+		if key := events[3].Command.Lookup("query", "_id").StringValue(); !strings.HasPrefix(key, "ip:") {
+			mt.Errorf("the failure was counted for %q, want the address", key)
+		}
+	})
+
+	mt.Run("a locked account isn't checked", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		useFastPolicy(mt)
+		user := userWithPassword(mt)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+			mtest.CreateCursorResponse(0, attemptsNS, mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		)
+		if response := change(mt, user); response.Code != http.StatusTooManyRequests {
+			mt.Fatalf("changepassword = %d %s, want %d", response.Code, response.Body, http.StatusTooManyRequests)
+		}
+		//nothing more is counted and nothing changes
+		if sent := commands(mt); len(sent) != 2 {
+			mt.Errorf("the database got %v, want only the user and the lock looked up", sent)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golangfinal/database"
+	"golangfinal/mailer"
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// GetProfile shows the profile of the user, GET /users/profile
+func GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, user.Profile())
+	}
+}
+
+/*
+UpdateProfile changes the names and the phone of the user, PATCH /users/profile.
+only the fields in the body are changed. the shop can't send texts, so the phone number
+isn't verified, it is only checked that no other account has it.
+the email is changed with /users/changeemail
+*/
+func UpdateProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			First_Name *string `json:"first_name" validate:"omitempty,min=2,max=30"`
+			Last_Name  *string `json:"last_name"  validate:"omitempty,min=2,max=30"`
+			Phone      *string `json:"phone"      validate:"omitempty,min=1"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		user, err := database.UpdateProfile(ctx, UserCollection, c.GetString("uid"), database.ProfileUpdate{
+			First_Name: body.First_Name,
+			Last_Name:  body.Last_Name,
+			Phone:      body.Phone,
+		})
+		switch err {
+		case nil:
+			c.JSON(http.StatusOK, user.Profile())
+		case database.ErrPhoneInUse:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case database.ErrUserIDIsNotValid:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+/*
+ChangeEmail starts a change of email, POST /users/changeemail {"email": ..., "password": ...}.
+the password is needed when the account has one. the new address gets a link to confirm it,
+the old one is told about the change, the email stays the same until the link is followed
+*/
+func ChangeEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Email    string `json:"email" validate:"email,required"`
+			Password string `json:"password"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body.Email = database.NormalizeEmail(body.Email)
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		if !confirmUser(ctx, c, user, body.Password, nil) {
+			return
+		}
+		if strings.EqualFold(body.Email, *user.Email) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "this is already your email"})
+			return
+		}
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": body.Email})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrEmailInUse.Error()})
+			return
+		}
+		err = database.CheckEmailRate(ctx, VerificationCollection, user.User_ID, database.PurposeChangeEmail)
+		if err == database.ErrTooManyEmails {
+			c.Header("Retry-After", fmt.Sprint(int(database.ResendInterval.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil {
+			err = database.SetPendingEmail(ctx, UserCollection, user.User_ID, body.Email)
+		}
+		if err == nil {
+			err = sendChangeEmail(ctx, user, body.Email)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot send the change email", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot send the email"})
+			return
+		}
+		c.JSON(http.StatusAccepted, "A link to confirm the new email was sent to it")
+	}
+}
+
+// sendChangeEmail sends the link to the new address and a notice to the old one
+func sendChangeEmail(ctx context.Context, user models.User, email string) error {
+	token, err := database.CreateVerificationToken(ctx, VerificationCollection, user.User_ID, database.PurposeChangeEmail, email, database.VerificationTokenLifetime)
+	if err != nil {
+		return err
+	}
+	link := AppBaseURL + "/users/confirmemail?token=" + url.QueryEscape(token)
+	err = Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your new email",
+		Body:    fmt.Sprintf("Hi %s,\n\nplease confirm your new email by opening this link:\n%s\n\nthe link works once and expires in %s.\n", *user.First_Name, link, database.VerificationTokenLifetime),
+	})
+	if err != nil {
+		return err
+	}
+	//the owner of the old address hears about it, in case someone else asked for the change
+	if err := Mailer.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Your email is being changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nsomeone asked to change the email of your account to %s.\nif it wasn't you, change your password and log out your sessions.\n", *user.First_Name, email),
+	}); err != nil {
+		slog.ErrorContext(ctx, "cannot notify the old email of the change", "error", err)
+	}
+	return nil
+}
+
+// ConfirmEmailChange is the link sent to the new address, GET /users/confirmemail?token=
+func ConfirmEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is empty"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		record, err := database.UseVerificationToken(ctx, VerificationCollection, token, database.PurposeChangeEmail)
+		if err == nil {
+			err = database.ChangeEmail(ctx, UserCollection, record.User_ID, record.Email)
+		}
+		switch err {
+		case nil:
+			c.JSON(http.StatusOK, "Email changed, use it the next time you log in")
+		case database.ErrInvalidVerificationToken:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case database.ErrEmailInUse:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+	}
+}
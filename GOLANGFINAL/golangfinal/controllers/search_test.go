@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSearchProductByQuery(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	search := func(name string) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/users/search", SearchProductByQuery())
+		return serve(router, httptest.NewRequest(http.MethodGet, "/users/search?name="+url.QueryEscape(name), nil))
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Alien", `^Alien`},
+		//a pattern is searched as the text it is
+		{"(a+)+$", `^\(a\+\)\+\$`},
+		{"x.*", `^x\.\*`},
+	}
+	for _, test := range tests {
+		mt.Run(test.query, func(mt *mtest.T) {
+			useMockDatabase(mt)
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "shop.Products", mtest.FirstBatch))
+			if response := search(test.query); response.Code != http.StatusOK {
+				mt.Fatalf("search = %d %s", response.Code, response.Body)
+			}
+			filter := mt.GetStartedEvent().Command.Lookup("filter", "product_name", "$regex").StringValue()
+			if filter != test.want {
+				mt.Errorf("the products are searched with %q, want %q", filter, test.want)
+			}
+		})
+	}
+
+	mt.Run("too long", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		if response := search(strings.Repeat("a", MaxSearchLength+1)); response.Code != http.StatusNotFound {
+			mt.Errorf("search = %d, want %d", response.Code, http.StatusNotFound)
+		}
+		if sent := commands(mt); len(sent) != 0 {
+			mt.Errorf("the database got %v", sent)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	"golangfinal/database"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var SessionCollection *mongo.Collection
+
+// ListSessions shows the devices the user is logged in on, GET /users/sessions
+func ListSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		sessions, err := database.ListSessions(ctx, SessionCollection, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = sessions[i].Session_ID.Hex() == c.GetString("sid")
+		}
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+// RevokeSession logs one of the sessions out, DELETE /users/sessions?id=
+func RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID := c.Query("id")
+		if sessionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "session id is empty"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err := database.RevokeSession(ctx, SessionCollection, c.GetString("uid"), sessionID)
+		if err == database.ErrCantFindSession {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully logged the session out")
+	}
+}
+
+// Logout logs the current session out, POST /users/logout
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		if err := database.RevokeSession(ctx, SessionCollection, c.GetString("uid"), c.GetString("sid")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully logged out")
+	}
+}
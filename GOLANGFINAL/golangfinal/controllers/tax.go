@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"golangfinal/database"
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the admin configures the tax rules, they are applied to the cart listing and to every order
+
+func AddTaxRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var rule models.TaxRule
+		if err := c.BindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rule.TaxRule_ID = primitive.NewObjectID()
+		_, err := TaxRuleCollection.InsertOne(ctx, rule)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot create the tax rule", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Not Created"})
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+func ListTaxRules() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		rules, err := database.GetTaxRules(ctx, TaxRuleCollection)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.IndentedJSON(200, rules)
+	}
+}
+
+func EditTaxRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule_id, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tax rule id"})
+			return
+		}
+		var rule models.TaxRule
+		if err := c.BindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		rule.TaxRule_ID = rule_id
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		result, err := TaxRuleCollection.ReplaceOne(ctx, bson.M{"_id": rule_id}, rule)
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot update the tax rule", "error", err)
+			c.IndentedJSON(500, "Something Went Wrong")
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tax rule not found"})
+			return
+		}
+		c.IndentedJSON(200, rule)
+	}
+}
+
+func DeleteTaxRule() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule_id, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tax rule id"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		result, err := TaxRuleCollection.DeleteOne(ctx, bson.M{"_id": rule_id})
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot delete the tax rule", "error", err)
+			c.IndentedJSON(500, "Something Went Wrong")
+			return
+		}
+		if result.DeletedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tax rule not found"})
+			return
+		}
+		c.IndentedJSON(200, "Successfully Deleted!")
+	}
+}
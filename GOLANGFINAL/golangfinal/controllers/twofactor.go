@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/models"
+	generate "golangfinal/tokens"
+	"golangfinal/totp"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TOTPIssuer is the name the authenticator apps show next to the codes
+var TOTPIssuer = "Ecommerce"
+
+// RecoveryCodeCount is how many recovery codes are made when two factor authentication is turned on
+const RecoveryCodeCount = 10
+
+// EnrollTwoFactor starts the two factor enrolment, POST /users/2fa/enroll
+func EnrollTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		err = database.StartTOTPEnrolment(ctx, UserCollection, user.User_ID, secret)
+		if err == database.ErrTwoFactorEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		//the uri is shown as a QR code, the secret is for typing it in by hand
+		c.JSON(http.StatusOK, gin.H{"secret": secret, "otpauth_uri": totp.URI(TOTPIssuer, *user.Email, secret)})
+	}
+}
+
+// ConfirmTwoFactor turns two factor authentication on with a first code and returns the recovery codes, POST /users/2fa/confirm
+func ConfirmTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		if user.TOTP_Enabled {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrTwoFactorEnabled.Error()})
+			return
+		}
+		if user.TOTP_Pending_Secret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrNoPendingTwoFactor.Error()})
+			return
+		}
+		step, ok := totp.Validate(*user.TOTP_Pending_Secret, body.Code, time.Now())
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrInvalidCode.Error()})
+			return
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		err = database.ConfirmTOTP(ctx, UserCollection, user.User_ID, *user.TOTP_Pending_Secret, step, hashes)
+		if err == database.ErrNoPendingTwoFactor {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		//the recovery codes are only shown this once
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// DisableTwoFactor turns two factor authentication off, it needs the password and a code, POST /users/2fa/disable
+func DisableTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Password string `json:"password" validate:"required"`
+			Code     string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"user_id": c.GetString("uid")}).Decode(&user); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": database.ErrUserIDIsNotValid.Error()})
+			return
+		}
+		if !user.TOTP_Enabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrTwoFactorNotEnabled.Error()})
+			return
+		}
+		if user.Password == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrNoPassword.Error()})
+			return
+		}
+		if !confirmUser(ctx, c, user, body.Password, &body.Code) {
+			return
+		}
+		if err := database.DisableTOTP(ctx, UserCollection, user.User_ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Two factor authentication is turned off")
+	}
+}
+
+/*
+LoginTwoFactor is the second step of a login with two factor authentication, POST /users/login/2fa.
+it takes the challenge from Login and a code from the app or a recovery code,
+wrong codes count as failed logins of the account
+*/
+func LoginTwoFactor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Challenge string `json:"challenge" validate:"required"`
+			Code      string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		claims, err := generate.ValidateChallengeToken(body.Challenge)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var founduser models.User
+		err = UserCollection.FindOne(ctx, bson.M{"user_id": claims.Challenge_Uid}).Decode(&founduser)
+		//a password change since the first step ends the challenge too
+		if err != nil || !founduser.TOTP_Enabled || founduser.Token_Version != claims.Token_Version {
+			loginFailed(c)
+			return
+		}
+		accountKey := database.AccountAttemptKey(*founduser.Email)
+		ipKey := database.IPAttemptKey(c.ClientIP())
+		err = database.CheckLoginAllowed(ctx, LoginAttemptCollection, accountKey, ipKey)
+		if err == database.ErrLoginLocked {
+			loginFailed(c)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err = checkSecondFactor(ctx, founduser, body.Code); err != nil {
+			recordLoginFailure(ctx, accountKey, ipKey)
+			loginFailed(c)
+			return
+		}
+		_, _ = database.ClearLoginFailures(ctx, LoginAttemptCollection, accountKey)
+		finishLogin(ctx, c, founduser)
+	}
+}
+
+// checkSecondFactor accepts a code of the app that wasn't used yet or an unused recovery code
+func checkSecondFactor(ctx context.Context, user models.User, code string) error {
+	if user.TOTP_Secret != nil {
+		if step, ok := totp.Validate(*user.TOTP_Secret, code, time.Now()); ok {
+			return database.UseTOTPStep(ctx, UserCollection, user.User_ID, step)
+		}
+	}
+	return database.UseRecoveryCode(ctx, UserCollection, user.User_ID, database.HashRecoveryCode(code))
+}
+
+// newRecoveryCodes makes the recovery codes to show and the hashes to save
+func newRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		random := make([]byte, 7)
+		if _, err := rand.Read(random); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(random)[:10])
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, database.HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"golangfinal/database"
+	"golangfinal/mailer"
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var VerificationCollection *mongo.Collection
+
+// Mailer sends the emails, main replaces the in-memory outbox with the one from the environment
+var Mailer mailer.Mailer = mailer.NewOutbox("")
+
+// AppBaseURL is put in front of the links in the emails
+var AppBaseURL = "http://localhost:8000"
+
+// sendVerificationEmail sends the user a link to verify the email address
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := database.CreateVerificationToken(ctx, VerificationCollection, user.User_ID, database.PurposeVerifyEmail, *user.Email, database.VerificationTokenLifetime)
+	if err != nil {
+		return err
+	}
+	link := AppBaseURL + "/users/verify?token=" + url.QueryEscape(token)
+	return Mailer.Send(ctx, mailer.Message{
+		To:      *user.Email,
+		Subject: "Verify your email",
+		Body:    fmt.Sprintf("Hi %s,\n\nplease verify your email by opening this link:\n%s\n\nthe link works once and expires in %s.\n", *user.First_Name, link, database.VerificationTokenLifetime),
+	})
+}
+
+// VerifyEmail is the link from the verification email, GET /users/verify?token=
+func VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Query("token")
+		if token == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is empty"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		record, err := database.UseVerificationToken(ctx, VerificationCollection, token, database.PurposeVerifyEmail)
+		if err == nil {
+			err = database.VerifyEmail(ctx, UserCollection, record.User_ID, record.Email)
+		}
+		if err == database.ErrInvalidVerificationToken {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Email verified, you can log in now")
+	}
+}
+
+/*
+ResendVerification sends a new verification email, POST /users/resendverification {"email": ...}.
+the answer is the same whether the address is known or not, and whether an email was sent or
+too many were sent already, so it can't be used to look up accounts
+*/
+func ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"email,required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		const sent = "If the account exists and isn't verified, a new email was sent"
+		var user models.User
+		err := UserCollection.FindOne(ctx, bson.M{"email": database.NormalizeEmail(body.Email)}).Decode(&user)
+		if err != nil || user.Email_Verified {
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+		err = database.CheckEmailRate(ctx, VerificationCollection, user.User_ID, database.PurposeVerifyEmail)
+		//a 429 would tell that the address has an account
+		if err == database.ErrTooManyEmails {
+			slog.InfoContext(ctx, "verification email not sent, too many were sent", "user_id", user.User_ID)
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+		if err == nil {
+			err = sendVerificationEmail(ctx, user)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "cannot send the verification email", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot send the email"})
+			return
+		}
+		c.JSON(http.StatusOK, sent)
+	}
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golangfinal/database"
+	"golangfinal/mailer"
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const (
+	usersNS  = "shop.Users"
+	tokensNS = "shop.VerificationTokens"
+)
+
+var verifyLink = regexp.MustCompile(`/users/verify\?token=(\S+)`)
+
+// useOutbox keeps the emails of the test in a fresh outbox
+func useOutbox(mt *mtest.T) *mailer.Outbox {
+	outbox := mailer.NewOutbox("")
+	saved := Mailer
+	Mailer = outbox
+	mt.Cleanup(func() { Mailer = saved })
+	return outbox
+}
+
+func verificationRouter() *gin.Engine {
+	router := gin.New()
+	router.GET("/users/verify", VerifyEmail())
+	router.POST("/users/resendverification", ResendVerification())
+	return router
+}
+
+func resend(router *gin.Engine, email string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/users/resendverification", strings.NewReader(`{"email": "`+email+`"}`))
+	request.Header.Set("Content-Type", "application/json")
+	return serve(router, request)
+}
+
+func testUser(verified bool) models.User {
+	first, last, email := "Ann", "Smith", "ann@example.com"
+	id := primitive.NewObjectID()
+	return models.User{ID: id, User_ID: id.Hex(), First_Name: &first, Last_Name: &last, Email: &email, Email_Verified: verified}
+}
+
+// emailsSent is the answer to the count of the emails sent to the user today
+func emailsSent(count int) bson.D {
+	return mtest.CreateCursorResponse(0, tokensNS, mtest.FirstBatch, bson.D{{Key: "n", Value: count}})
+}
+
+func TestResendVerification(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	const sent = `"If the account exists and isn't verified, a new email was sent"`
+
+	mt.Run("sends a link", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		outbox := useOutbox(mt)
+		user := testUser(false)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+			emailsSent(0),
+			noDocument(tokensNS),
+			mtest.CreateSuccessResponse(),
+		)
+		response := resend(verificationRouter(), "Ann@Example.com")
+		if response.Code != http.StatusOK || response.Body.String() != sent {
+			mt.Fatalf("resend = %d %s, want %d %s", response.Code, response.Body, http.StatusOK, sent)
+		}
+		//the address is looked up in lower case
+		if email := mt.GetStartedEvent().Command.Lookup("filter", "email").StringValue(); email != "ann@example.com" {
+			mt.Errorf("the user is looked up by %q", email)
+		}
+		message, found := outbox.Last("ann@example.com")
+		if !found || len(outbox.Messages()) != 1 {
+			mt.Fatalf("the outbox has %v, want one email to ann@example.com", outbox.Messages())
+		}
+		match := verifyLink.FindStringSubmatch(message.Body)
+		if match == nil || !strings.Contains(message.Body, AppBaseURL+match[0]) {
+			mt.Fatalf("the email has no verification link: %s", message.Body)
+		}
+		token, err := url.QueryUnescape(match[1])
+		if err != nil {
+			mt.Fatal(err)
+		}
+		//only the hash of the token is saved, the link has the token itself
+		var insert bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "insert" {
+				insert = event.Command
+			}
+		}
+		if insert == nil {
+			mt.Fatal("no token was saved")
+		}
+		saved := insert.Lookup("documents").Array().Index(0).Value().Document()
+		sum := sha256.Sum256([]byte(token))
+		if saved.Lookup("token_hash").StringValue() != hex.EncodeToString(sum[:]) || strings.Contains(saved.String(), token) {
+			mt.Errorf("saved token = %s, want only the hash of %q", saved, token)
+		}
+		if saved.Lookup("purpose").StringValue() != database.PurposeVerifyEmail || saved.Lookup("user_id").StringValue() != user.User_ID {
+			mt.Errorf("saved token = %s, want a %s token of %s", saved, database.PurposeVerifyEmail, user.User_ID)
+		}
+	})
+
+	//every case answers like a sent email, so the answer doesn't tell if the address has an account
+	silent := []struct {
+		name      string
+		responses func(mt *mtest.T) []bson.D
+	}{
+		{"unknown address", func(mt *mtest.T) []bson.D {
+			return []bson.D{noDocument(usersNS)}
+		}},
+		{"already verified", func(mt *mtest.T) []bson.D {
+			return []bson.D{mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, testUser(true)))}
+		}},
+		{"too many today", func(mt *mtest.T) []bson.D {
+			return []bson.D{
+				mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, testUser(false))),
+				emailsSent(database.MaxEmailsPerDay),
+			}
+		}},
+		{"sent a moment ago", func(mt *mtest.T) []bson.D {
+			user := testUser(false)
+			last := models.VerificationToken{Token_ID: primitive.NewObjectID(), User_ID: user.User_ID, Purpose: database.PurposeVerifyEmail, Created_At: time.Now()}
+			return []bson.D{
+				mtest.CreateCursorResponse(0, usersNS, mtest.FirstBatch, document(mt, user)),
+				emailsSent(1),
+				mtest.CreateCursorResponse(0, tokensNS, mtest.FirstBatch, document(mt, last)),
+			}
+		}},
+	}
+	for _, test := range silent {
+		mt.Run(test.name, func(mt *mtest.T) {
+			useMockDatabase(mt)
+			outbox := useOutbox(mt)
+			mt.AddMockResponses(test.responses(mt)...)
+			response := resend(verificationRouter(), "ann@example.com")
+			if response.Code != http.StatusOK || response.Body.String() != sent {
+				mt.Errorf("resend = %d %s, want %d %s", response.Code, response.Body, http.StatusOK, sent)
+			}
+			if messages := outbox.Messages(); len(messages) != 0 {
+				mt.Errorf("emails were sent: %v", messages)
+			}
+		})
+	}
+
+	mt.Run("invalid address", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		if response := resend(verificationRouter(), "not an address"); response.Code != http.StatusBadRequest {
+			mt.Errorf("resend = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	verify := func(token string) *httptest.ResponseRecorder {
+		return serve(verificationRouter(), httptest.NewRequest(http.MethodGet, "/users/verify?token="+url.QueryEscape(token), nil))
+	}
+
+	mt.Run("valid link", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		user := testUser(false)
+		record := models.VerificationToken{Token_ID: primitive.NewObjectID(), User_ID: user.User_ID, Purpose: database.PurposeVerifyEmail, Email: *user.Email}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: document(mt, record)}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		response := verify("the-token")
+		if response.Code != http.StatusOK {
+			mt.Fatalf("verify = %d %s, want %d", response.Code, response.Body, http.StatusOK)
+		}
+		events := mt.GetAllStartedEvents()
+		if len(events) != 2 || events[0].CommandName != "findAndModify" || events[1].CommandName != "update" {
+			mt.Fatalf("the database got %v, want the token used and the user updated", commands(mt))
+		}
+		sum := sha256.Sum256([]byte("the-token"))
+		if hash := events[0].Command.Lookup("query", "token_hash").StringValue(); hash != hex.EncodeToString(sum[:]) {
+			mt.Errorf("the token is looked up by %q, want its hash", hash)
+		}
+		//only while the user still has the address the link was sent to
+		update := events[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if email := update.Lookup("q", "email").StringValue(); email != *user.Email {
+			mt.Errorf("update = %s, want it only for %s", update, *user.Email)
+		}
+	})
+
+	mt.Run("unknown or used token", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		if response := verify("the-token"); response.Code != http.StatusBadRequest {
+			mt.Errorf("verify = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+		if sent := commands(mt); len(sent) != 1 {
+			mt.Errorf("the database got %v, want only the token looked up", sent)
+		}
+	})
+
+	mt.Run("email changed since", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		user := testUser(false)
+		record := models.VerificationToken{Token_ID: primitive.NewObjectID(), User_ID: user.User_ID, Purpose: database.PurposeVerifyEmail, Email: "old@example.com"}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "value", Value: document(mt, record)}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}),
+		)
+		if response := verify("the-token"); response.Code != http.StatusBadRequest {
+			mt.Errorf("verify = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+	})
+
+	mt.Run("no token", func(mt *mtest.T) {
+		useMockDatabase(mt)
+		if response := verify(""); response.Code != http.StatusBadRequest {
+			mt.Errorf("verify = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+		}
+		if sent := commands(mt); len(sent) != 0 {
+			mt.Errorf("the database got %v", sent)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"golangfinal/database"
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// the wishlists belong to the user that is logged in, the id comes from the token
+
+func (app *Application) CreateWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var wishlist models.Wishlist
+		if err := c.BindJSON(&wishlist); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(wishlist); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		wishlist, err := database.CreateWishlist(ctx, app.userCollection, c.GetString("uid"), *wishlist.Name)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.IndentedJSON(http.StatusCreated, wishlist)
+	}
+}
+
+// ListWishlists returns the wishlists and the products saved for later
+func (app *Application) ListWishlists() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.IndentedJSON(http.StatusBadRequest, database.ErrUserIDIsNotValid.Error())
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		var user models.User
+		err = app.userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+		if err != nil {
+			slog.WarnContext(ctx, "cannot find the user", "error", err)
+			c.IndentedJSON(http.StatusNotFound, "not id found")
+			return
+		}
+		c.IndentedJSON(200, gin.H{"wishlists": user.Wishlists, "saved_for_later": user.Saved_For_Later})
+	}
+}
+
+func (app *Application) DeleteWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wishlistID, err := primitive.ObjectIDFromHex(c.Query("wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wishlist id"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err = database.DeleteWishlist(ctx, app.userCollection, c.GetString("uid"), wishlistID)
+		if err != nil {
+			c.IndentedJSON(wishlistStatus(err), err.Error())
+			return
+		}
+		c.IndentedJSON(200, "Successfully Deleted!")
+	}
+}
+
+func (app *Application) AddToWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wishlistID, err := primitive.ObjectIDFromHex(c.Query("wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wishlist id"})
+			return
+		}
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is invalid"))
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err = database.AddProductToWishlist(ctx, app.prodCollection, app.userCollection, productID, c.GetString("uid"), wishlistID)
+		if err != nil {
+			c.IndentedJSON(wishlistStatus(err), err.Error())
+			return
+		}
+		c.IndentedJSON(200, "Successfully Added to the wishlist")
+	}
+}
+
+func (app *Application) RemoveFromWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wishlistID, err := primitive.ObjectIDFromHex(c.Query("wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wishlist id"})
+			return
+		}
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is invalid"))
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err = database.RemoveWishlistItem(ctx, app.userCollection, productID, c.GetString("uid"), wishlistID)
+		if err != nil {
+			c.IndentedJSON(wishlistStatus(err), err.Error())
+			return
+		}
+		c.IndentedJSON(200, "Successfully removed from the wishlist")
+	}
+}
+
+/*
+MoveItem moves a product between the cart, the saved for later list and the wishlists:
+/moveitem?id=<product>&from=cart&to=saved saves it for later,
+/moveitem?id=<product>&from=wishlist&from_wishlist=<id>&to=cart buys it from a wishlist
+*/
+func (app *Application) MoveItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(c.Query("id"))
+		if err != nil {
+			_ = c.AbortWithError(http.StatusBadRequest, errors.New("product id is invalid"))
+			return
+		}
+		from, err := itemList(c.Query("from"), c.Query("from_wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		to, err := itemList(c.Query("to"), c.Query("to_wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err = database.MoveItem(ctx, app.userCollection, productID, c.GetString("uid"), from, to)
+		if err != nil {
+			c.IndentedJSON(wishlistStatus(err), err.Error())
+			return
+		}
+		c.IndentedJSON(200, "Successfully moved the item")
+	}
+}
+
+// ShareWishlist returns the read only link of the wishlist
+func (app *Application) ShareWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wishlistID, err := primitive.ObjectIDFromHex(c.Query("wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wishlist id"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		token, err := database.ShareWishlist(ctx, app.userCollection, c.GetString("uid"), wishlistID)
+		if err != nil {
+			c.IndentedJSON(wishlistStatus(err), err.Error())
+			return
+		}
+		scheme := "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+		c.IndentedJSON(200, gin.H{"share_token": token, "link": scheme + "://" + c.Request.Host + "/wishlists/shared/" + token})
+	}
+}
+
+func (app *Application) UnshareWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wishlistID, err := primitive.ObjectIDFromHex(c.Query("wishlist"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wishlist id"})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		err = database.UnshareWishlist(ctx, app.userCollection, c.GetString("uid"), wishlistID)
+		if err != nil {
+			c.IndentedJSON(wishlistStatus(err), err.Error())
+			return
+		}
+		c.IndentedJSON(200, "The wishlist is not shared anymore")
+	}
+}
+
+// SharedWishlist is public, anyone with the link can see the wishlist but not change it
+func SharedWishlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(requestContext(c), QueryTimeout)
+		defer cancel()
+		wishlist, err := database.GetSharedWishlist(ctx, UserCollection, c.Param("token"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		//the token is the link itself, it isn't repeated in the response
+		wishlist.Share_Token = nil
+		c.IndentedJSON(200, wishlist)
+	}
+}
+
+func itemList(list string, wishlist string) (database.ItemList, error) {
+	switch list {
+	case database.ListCart, database.ListSaved:
+		return database.ItemList{List: list}, nil
+	case database.ListWishlist:
+		wishlistID, err := primitive.ObjectIDFromHex(wishlist)
+		if err != nil {
+			return database.ItemList{}, errors.New("invalid wishlist id")
+		}
+		return database.ItemList{List: list, Wishlist_ID: wishlistID}, nil
+	}
+	return database.ItemList{}, database.ErrInvalidList
+}
+
+func wishlistStatus(err error) int {
+	switch err {
+	case database.ErrCantFindWishlist, database.ErrItemNotInList, database.ErrCantFindProduct:
+		return http.StatusNotFound
+	case database.ErrUserIDIsNotValid, database.ErrInvalidList:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
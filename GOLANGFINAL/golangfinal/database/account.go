@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantExportData    = errors.New("cannot collect the data of the account")
+	ErrCantDeleteAccount = errors.New("cannot delete the account")
+)
+
+// FindReviews lists the comments the user wrote on products
+func FindReviews(ctx context.Context, prodCollection *mongo.Collection, userID string) ([]models.Review, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"comment.user_id": userID}}},
+		{{Key: "$unwind", Value: "$comment"}},
+		{{Key: "$match", Value: bson.M{"comment.user_id": userID}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"product_id":   "$_id",
+			"product_name": "$product_name",
+			"comment_id":   "$comment._id",
+			"comment":      "$comment.comment",
+			"rating":       "$comment.rating",
+		}}},
+	}
+	cursor, err := prodCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logError(ctx, err)
+		return nil, ErrCantExportData
+	}
+	reviews := make([]models.Review, 0)
+	if err = cursor.All(ctx, &reviews); err != nil {
+		logError(ctx, err)
+		return nil, ErrCantExportData
+	}
+	return reviews, nil
+}
+
+/*
+AnonymizeReviews takes the user off their comments, the comments and ratings stay on the products.
+the comments written before the author was saved with them have no user_id, nobody knows whose
+they are, so they are neither exported nor changed here
+*/
+func AnonymizeReviews(ctx context.Context, prodCollection *mongo.Collection, userID string) error {
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"c.user_id": userID}}})
+	update := bson.M{"$unset": bson.M{"comment.$[c].user_id": ""}}
+	_, err := prodCollection.UpdateMany(ctx, bson.M{"comment.user_id": userID}, update, opts)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantDeleteAccount
+	}
+	return nil
+}
+
+/*
+AnonymizeUser removes the personal data of the user: names, contact details, password,
+addresses, cart, wishlists, linked providers and second factor. the orders stay for the books,
+they are kept under the user id with nothing left that names the person
+*/
+func AnonymizeUser(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"address":         make([]models.Address, 0),
+			"usercart":        make([]models.ProductUser, 0),
+			"saved_for_later": make([]models.ProductUser, 0),
+			"wishlists":       make([]models.Wishlist, 0),
+			"identities":      make([]models.Identity, 0),
+			"email_verified":  false,
+			"totp_enabled":    false,
+			"deleted_at":      now,
+			"updated_at":      now,
+		},
+		"$unset": bson.M{
+			"first_name":          "",
+			"last_name":           "",
+			"email":               "",
+			"phone":               "",
+			"password":            "",
+			"token":               "",
+			"refresh_token":       "",
+			"verified_at":         "",
+			"pending_email":       "",
+			"scopes":              "",
+			"totp_secret":         "",
+			"totp_pending_secret": "",
+			"recovery_codes":      "",
+		},
+		"$inc": bson.M{"token_version": 1},
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID, "deleted_at": nil}, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantDeleteAccount
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserIDIsNotValid
+	}
+	return nil
+}
+
+// DeleteUserRecords deletes the documents of the user, like sessions and emailed tokens, from every collection
+func DeleteUserRecords(ctx context.Context, userID string, collections ...*mongo.Collection) error {
+	for _, collection := range collections {
+		if _, err := collection.DeleteMany(ctx, bson.M{"user_id": userID}); err != nil {
+			logError(ctx, err)
+			return ErrCantDeleteAccount
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidAPIKey    = errors.New("the api key is invalid, expired or revoked")
+	ErrCantFindAPIKey   = errors.New("cannot find the api key")
+	ErrCantCreateAPIKey = errors.New("cannot create the api key")
+)
+
+// APIKeyPrefix starts every api key, so they are easy to tell from tokens and to find in leaked code
+const APIKeyPrefix = "sk_"
+
+/*
+CreateAPIKey makes a new key, saves its hash and returns the key itself,
+which is only shown this once. the key looks like sk_<prefix>_<secret>,
+the prefix is saved to tell the keys apart in the list
+*/
+func CreateAPIKey(ctx context.Context, apiKeyCollection *mongo.Collection, apiKey models.APIKey) (models.APIKey, string, error) {
+	prefix := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return apiKey, "", ErrCantCreateAPIKey
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return apiKey, "", ErrCantCreateAPIKey
+	}
+	apiKey.Key_ID = primitive.NewObjectID()
+	apiKey.Prefix = APIKeyPrefix + hex.EncodeToString(prefix)
+	key := apiKey.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey.Key_Hash = hashToken(key)
+	apiKey.Created_At = time.Now()
+	apiKey.Revoked_At = nil
+	apiKey.Last_Used_At = nil
+	apiKey.Last_Used_IP = ""
+	if _, err := apiKeyCollection.InsertOne(ctx, apiKey); err != nil {
+		logError(ctx, err)
+		return apiKey, "", ErrCantCreateAPIKey
+	}
+	return apiKey, key, nil
+}
+
+// IsAPIKey tells if a credential is an api key rather than a token
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// UseAPIKey returns the active key and notes when and from where it was used
+func UseAPIKey(ctx context.Context, apiKeyCollection *mongo.Collection, key string, ip string) (models.APIKey, error) {
+	var apiKey models.APIKey
+	now := time.Now()
+	filter := bson.M{
+		"key_hash":   hashToken(key),
+		"revoked_at": nil,
+		"$or":        bson.A{bson.M{"expires_at": nil}, bson.M{"expires_at": bson.M{"$gt": now}}},
+	}
+	err := apiKeyCollection.FindOne(ctx, filter).Decode(&apiKey)
+	if err == mongo.ErrNoDocuments {
+		return apiKey, ErrInvalidAPIKey
+	}
+	if err != nil {
+		logError(ctx, err)
+		return apiKey, ErrCantFindAPIKey
+	}
+	//like the sessions, the time is only written again after a while
+	if apiKey.Last_Used_At == nil || now.Sub(*apiKey.Last_Used_At) > lastSeenInterval || apiKey.Last_Used_IP != ip {
+		_, err = apiKeyCollection.UpdateOne(ctx, bson.M{"_id": apiKey.Key_ID}, bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": ip}})
+		if err != nil {
+			logError(ctx, err)
+		}
+	}
+	return apiKey, nil
+}
+
+// ListAPIKeys returns every key, the newest first
+func ListAPIKeys(ctx context.Context, apiKeyCollection *mongo.Collection) ([]models.APIKey, error) {
+	cursor, err := apiKeyCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"created_at": -1}))
+	if err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindAPIKey
+	}
+	apiKeys := make([]models.APIKey, 0)
+	if err = cursor.All(ctx, &apiKeys); err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindAPIKey
+	}
+	return apiKeys, nil
+}
+
+// RevokeAPIKey stops the key from working, the record stays for the history
+func RevokeAPIKey(ctx context.Context, apiKeyCollection *mongo.Collection, keyID string) error {
+	id, err := primitive.ObjectIDFromHex(keyID)
+	if err != nil {
+		return ErrCantFindAPIKey
+	}
+	result, err := apiKeyCollection.UpdateOne(ctx, bson.M{"_id": id, "revoked_at": nil}, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantFindAPIKey
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindAPIKey
+	}
+	return nil
+}
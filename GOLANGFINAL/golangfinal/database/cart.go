@@ -3,9 +3,9 @@ package database
 import (
 	"context"
 	"errors"
-	"log"
 	"time"
 
+	"golangfinal/metrics"
 	"golangfinal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -22,6 +22,7 @@ var (
 	ErrCantRemoveItem     = errors.New("cannot remove item from cart")
 	ErrCantGetItem        = errors.New("cannot get item from cart ")
 	ErrCantBuyCartItem    = errors.New("cannot update the purchase")
+	ErrCartIsEmpty        = errors.New("there is nothing in the cart to buy")
 )
 
 func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
@@ -31,7 +32,7 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 	searchfromdb, err := prodCollection.Find(ctx, bson.M{"_id": productID})
 	//now that u got it from db check for err
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return ErrCantFindProduct
 	}
 
@@ -40,33 +41,66 @@ func AddProductToCart(ctx context.Context, prodCollection, userCollection *mongo
 	//.All() is putting a product from searchfromdb to the productCart
 	err = searchfromdb.All(ctx, &productcart)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return ErrCantDecodeProducts
 	}
 
+	if len(productcart) == 0 {
+		return ErrCantFindProduct
+	}
+
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return ErrUserIDIsNotValid
 	}
 	//to update smth u need id(user id)
-	
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{{Key: "$each", Value: productcart}}}}}}
-	
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	err = addCartLine(ctx, userCollection, id, productcart[0], 1)
+	if err == mongo.ErrNoDocuments {
+		return ErrUserIDIsNotValid
+	}
 	if err != nil {
+		logError(ctx, err)
 		return ErrCantUpdateUser
 	}
 	//if everything is well u return nil instead of the error
 	return nil
 }
 
+/*
+addCartLine adds pieces of the product to the usercart of the document with the id.
+a product that is already in the cart gets its quantity raised instead of a second line,
+mongo.ErrNoDocuments means there is no document with that id
+*/
+func addCartLine(ctx context.Context, collection *mongo.Collection, ownerID primitive.ObjectID, product models.ProductUser, quantity int) error {
+	//the $ points at the cart line that matched the filter
+	filter := bson.M{"_id": ownerID, "usercart._id": product.Product_ID}
+	update := bson.M{"$inc": bson.M{"usercart.$.quantity": quantity}}
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+	//the price of the product is kept as it is now, the checkout compares it again
+	product.Quantity = quantity
+	product.Added_At = time.Now()
+	update = bson.M{"$push": bson.M{"usercart": product}}
+	result, err = collection.UpdateOne(ctx, bson.M{"_id": ownerID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
 func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return ErrUserIDIsNotValid
 	}
 	//USER - is a collection
@@ -94,12 +128,23 @@ func RemoveCartItem(ctx context.Context, prodCollection, userCollection *mongo.C
 
 }
 
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+/*
+BuyItemFromCart places the order of everything in the cart.
+the cart lines keep the price from the moment they were added, so the products are checked again:
+when a price changed or a product can't be bought anymore a *CartChangedError is returned
+and the order is only placed once the same changes come back acknowledged
+*/
+func BuyItemFromCart(ctx context.Context, prodCollection, userCollection, taxCollection, rateCollection *mongo.Collection, userID string, currency string, acknowledged string) error {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return ErrUserIDIsNotValid
 	}
+	//the rate of the currency the user sees the prices in is recorded on the order
+	rate, err := GetExchangeRate(ctx, rateCollection, currency)
+	if err != nil {
+		return err
+	}
 	var getcartitems models.User
 	var ordercart models.Order
 	//checkout:
@@ -107,107 +152,104 @@ func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, user
 	//initializing the fields from the Order struct
 	ordercart.Order_ID = primitive.NewObjectID()
 	ordercart.Orderered_At = time.Now()
-	//make an empty slice of the user's product cart that is ALL an order now
-
-	ordercart.Order_Cart = make([]models.ProductUser, 0)
 	ordercart.Payment_Method.COD = true
-	
+
 	//fetch the cart of the user
+	//check the prices and the stock of the products again
+	//find the tax of every line using the shipping address
 	//find the cart total price
 	//create an order with the items
 	//added order to the user Collection
-	//added items in the cart to order list
 	//empty up the cart
 
-	//find the cart total price: 
-
-	//UserCart of the user contains multiple instances of Product Model
-	//we need to flatte this data in the form {usercart}-apple,{usercart}-grapes,{usercart}-banana etc.
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-	//here The path parameter is used by the Atlas Search operators to specify the field or fields to be searched
-
-	//group the flattened data by the !id of the user, find out the !total  by !summing the !price field 
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
+	//find the _id field which is =="id" value
+	//using MongoDB query syntax
+	//"decode" method decodes the result of the query
+	//and maps it to the "getcartitems" variable
+	//if there is no match for the query, the 'err' msg will appear
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getcartitems)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantBuyCartItem
+	}
 
-	currentresults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-	ctx.Done()
+	items, changes, err := CheckCart(ctx, prodCollection, getcartitems.UserCart)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	var getusercart []bson.M
-		
-	if err = currentresults.All(ctx, &getusercart); err != nil {
-		panic(err)
+	if !changes.Empty() && changes.Acknowledge != acknowledged {
+		return &CartChangedError{Changes: changes}
 	}
-
-	var total_price int32
-	for _, user_item := range getusercart {
-		price := user_item["total"]
-		total_price = price.(int32)
-		//int's size is platform-dependent, whereas int32's size is always 32 bits.
+	if len(items) == 0 {
+		return ErrCartIsEmpty
 	}
-	//finished creating the fields of the order
-	ordercart.Price = int(total_price)
-
-	//create an order itself
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordercart}}}}
-
-	_, err = userCollection.UpdateMany(ctx, filter, update)
 
+	rules, err := GetTaxRules(ctx, taxCollection)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	//find the _id field which is =="id" value
-	//using MongoDB query syntax
-	//"decode" method decodes the result of the query
-	//and maps it to the "getcartitems" variable
-	//if there is no match for the query, the 'err' msg will appear
-
-	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getcartitems)
+	//every line of the cart gets its own tax depending on the product category
+	//the total price is the sum of the lines with the tax included
+	taxlines, totaltax, totalprice, err := CalculateTax(rules, ShippingAddress(getcartitems), items)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
+		return ErrCantBuyCartItem
 	}
-	//adding an orderlist
+
+	//finished creating the fields of the order
 	//order_list is the alias of orderCart
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	
-	//creating an update operation that will add items to a MongoDB array field called order_list
-	//the "$each" operator allows multiple items to be added to the array at once
-	//The items being added to the array are from the "getcartitems.UserCart" variable.
-	//The "$[]" operator is used to target all elements in the "orders" array
-	
-	//orders is an alias for the orderCart
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": bson.M{"$each": getcartitems.UserCart}}}
+	ordercart.Order_Cart = items
+	ordercart.Tax_Lines = taxlines
+	ordercart.Tax_Total = totaltax
+	ordercart.Price = totalprice
+	ordercart.Exchange_Rate, err = rate.Applied(totalprice)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantConvertPrices
+	}
 
-	//_ stands for the number of the documents modified, and we ignore it
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	//the pieces are taken from the stock before the order exists
+	//and given back if the order can't be saved
+	if err = TakeStock(ctx, prodCollection, items); err != nil {
+		return err
+	}
 
-	if err != nil {
-		log.Println(err)
+	//create an order itself and empty up the cart in the same update,
+	//so the order never exists while the bought products are still in the cart.
+	//only the lines of the products that were bought are pulled, the ones that couldn't be bought stay in it
+	bought := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		bought = append(bought, item.Product_ID)
 	}
-	//emptying the cart after buying everything
-	usercart_empty := make([]models.ProductUser, 0)
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 
-	filtered := bson.D{primitive.E{Key: "_id", Value: id}}
-	updated := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: usercart_empty}}}}
+	update := bson.D{
+		{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordercart}}},
+		{Key: "$pull", Value: bson.D{primitive.E{Key: "usercart", Value: bson.M{"_id": bson.M{"$in": bought}}}}},
+	}
 
-	_, err = userCollection.UpdateOne(ctx, filtered, updated)
+	_, err = userCollection.UpdateOne(ctx, filter, update)
 
 	if err != nil {
+		logError(ctx, err)
+		ReturnStock(ctx, prodCollection, items)
 		return ErrCantBuyCartItem
 	}
+	metrics.OrderPlaced("cart", ordercart.Price)
 	return nil
 }
 
-func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, UserID string) error {
+func InstantBuyer(ctx context.Context, prodCollection, userCollection, taxCollection, rateCollection *mongo.Collection, productID primitive.ObjectID, UserID string, currency string) error {
 	//instant buy - taking a product and not putting it into the cart but buying it instantly instead
 	id, err := primitive.ObjectIDFromHex(UserID)
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
 		return ErrUserIDIsNotValid
 	}
+	rate, err := GetExchangeRate(ctx, rateCollection, currency)
+	if err != nil {
+		return err
+	}
 	//taking the structure from the Product Cart
 	var product_details models.ProductUser
 	//even though u dont have to put a product in the cart, u still have to creat an order for it
@@ -217,34 +259,53 @@ func InstantBuyer(ctx context.Context, prodCollection, userCollection *mongo.Col
 
 	orders_detail.Order_ID = primitive.NewObjectID()
 	orders_detail.Orderered_At = time.Now()
-	orders_detail.Order_Cart = make([]models.ProductUser, 0)
 	orders_detail.Payment_Method.COD = true
-	
 
 	//finding the product u want to instantly buy, decode it and put it in the cart
 	err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product_details)
 
 	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
+		return ErrCantFindProduct
 	}
-	//checkout 
-	//the total price ==the price of the product
-	orders_detail.Price = product_details.Price
-	
+	//the user is needed for the shipping address the tax depends on
+	var buyer models.User
+	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&buyer)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	rules, err := GetTaxRules(ctx, taxCollection)
+	if err != nil {
+		return err
+	}
+	//checkout
+	//the total price ==the price of the product with its tax
+	orders_detail.Order_Cart = []models.ProductUser{product_details}
+	orders_detail.Tax_Lines, orders_detail.Tax_Total, orders_detail.Price, err = CalculateTax(rules, ShippingAddress(buyer), orders_detail.Order_Cart)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantBuyCartItem
+	}
+	orders_detail.Exchange_Rate, err = rate.Applied(orders_detail.Price)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantConvertPrices
+	}
+	if err = TakeStock(ctx, prodCollection, orders_detail.Order_Cart); err != nil {
+		return err
+	}
+
 	//id of the user that wanted to buy that product is used in the filter
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orders_detail}}}}
 	_, err = userCollection.UpdateOne(ctx, filter, update)
 
 	if err != nil {
-		log.Println(err)
-	}
-	//orders is an alies for the order cart
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].order_list": product_details}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
-	if err != nil {
-		log.Println(err)
+		logError(ctx, err)
+		ReturnStock(ctx, prodCollection, orders_detail.Order_Cart)
+		return ErrCantBuyCartItem
 	}
+	metrics.OrderPlaced("instant", orders_detail.Price)
 	return nil
 }
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func userDoc(mt *mtest.T, user models.User) bson.D {
+	mt.Helper()
+	data, err := bson.Marshal(user)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		mt.Fatal(err)
+	}
+	return doc
+}
+
+func TestBuyItemFromCart(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	user := models.User{ID: primitive.NewObjectID(), UserCart: []models.ProductUser{
+		{Product_ID: first, Price: models.NewMoney(1000, "USD"), Quantity: 2},
+		{Product_ID: second, Price: models.NewMoney(500, "USD"), Quantity: 1},
+	}}
+	//the user, the products of the cart and the tax rules are read before anything is written
+	reads := func(mt *mtest.T) []bson.D {
+		return []bson.D{
+			mtest.CreateCursorResponse(0, "shop.Users", mtest.FirstBatch, userDoc(mt, user)),
+			mtest.CreateCursorResponse(0, "shop.Products", mtest.FirstBatch,
+				productDoc(mt, first, 1000, intPtr(10)), productDoc(mt, second, 500, intPtr(10))),
+			mtest.CreateCursorResponse(0, "shop.TaxRules", mtest.FirstBatch),
+		}
+	}
+	stockTaken := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	updates := func(mt *mtest.T) []bson.Raw {
+		var commands []bson.Raw
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" {
+				commands = append(commands, event.Command.Lookup("updates").Array().Index(0).Value().Document())
+			}
+		}
+		return commands
+	}
+
+	mt.Run("order and cart in one update", func(mt *mtest.T) {
+		mt.AddMockResponses(reads(mt)...)
+		mt.AddMockResponses(stockTaken, stockTaken, mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+		err := BuyItemFromCart(context.Background(), mt.Coll, mt.Coll, mt.Coll, mt.Coll, user.ID.Hex(), "", "")
+		if err != nil {
+			mt.Fatal(err)
+		}
+		sent := updates(mt)
+		if len(sent) != 3 {
+			mt.Fatalf("%d updates, want the stock of two products and one of the user", len(sent))
+		}
+		order := sent[2]
+		if order.Lookup("q", "_id").ObjectID() != user.ID {
+			mt.Errorf("update = %s, want it for the user", order)
+		}
+		if lines, err := order.Lookup("u", "$push", "orders", "order_list").Array().Values(); err != nil || len(lines) != 2 {
+			mt.Errorf("update = %s, want the order with both lines pushed", order)
+		}
+		//the bought lines are pulled, the cart isn't overwritten with what was read before
+		if set := order.Lookup("u", "$set"); set.Type != 0 {
+			mt.Errorf("update = %s, want no $set of the cart", order)
+		}
+		pulled, err := order.Lookup("u", "$pull", "usercart", "_id", "$in").Array().Values()
+		if err != nil || len(pulled) != 2 || pulled[0].ObjectID() != first || pulled[1].ObjectID() != second {
+			mt.Errorf("update = %s, want both products pulled from the cart", order)
+		}
+	})
+
+	mt.Run("the stock is given back when the order can't be saved", func(mt *mtest.T) {
+		mt.AddMockResponses(reads(mt)...)
+		mt.AddMockResponses(stockTaken, stockTaken, mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 1, Message: "write failed"}),
+			stockTaken, stockTaken)
+		err := BuyItemFromCart(context.Background(), mt.Coll, mt.Coll, mt.Coll, mt.Coll, user.ID.Hex(), "", "")
+		if err != ErrCantBuyCartItem {
+			mt.Fatalf("error = %v, want %v", err, ErrCantBuyCartItem)
+		}
+		sent := updates(mt)
+		if len(sent) != 5 {
+			mt.Fatalf("%d updates, want the stock taken, the failed order and the stock given back", len(sent))
+		}
+		for _, returned := range sent[3:] {
+			if n := returned.Lookup("u", "$inc", "stock").Int32(); n <= 0 {
+				mt.Errorf("update = %s, want the stock given back", returned)
+			}
+		}
+	})
+
+	mt.Run("nothing is written for an empty cart", func(mt *mtest.T) {
+		empty := models.User{ID: user.ID, UserCart: []models.ProductUser{}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "shop.Users", mtest.FirstBatch, userDoc(mt, empty)),
+			mtest.CreateCursorResponse(0, "shop.Products", mtest.FirstBatch),
+		)
+		if err := BuyItemFromCart(context.Background(), mt.Coll, mt.Coll, mt.Coll, mt.Coll, user.ID.Hex(), "", ""); err != ErrCartIsEmpty {
+			mt.Errorf("error = %v, want %v", err, ErrCartIsEmpty)
+		}
+		if sent := updates(mt); len(sent) != 0 {
+			mt.Errorf("an empty cart wrote %v", sent)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrOutOfStock      = errors.New("the product is out of stock")
+	ErrCantUpdateStock = errors.New("cannot update the stock")
+)
+
+// reasons a cart line can't be bought anymore
+const (
+	ReasonRemoved    = "removed"
+	ReasonOutOfStock = "out_of_stock"
+)
+
+// PriceChange is a cart line whose product costs something else now than when it was added
+type PriceChange struct {
+	Product_ID   primitive.ObjectID `json:"product_id"`
+	Product_Name *string            `json:"product_name"`
+	Cart_Price   models.Money       `json:"cart_price"`
+	Price        models.Money       `json:"price"`
+}
+
+// UnavailableItem is a cart line that can't be bought, or not in the quantity of the cart
+type UnavailableItem struct {
+	Product_ID   primitive.ObjectID `json:"product_id"`
+	Product_Name *string            `json:"product_name"`
+	Reason       string             `json:"reason"`
+	Quantity     int                `json:"quantity"`
+	Available    int                `json:"available"`
+}
+
+/*
+CartChanges lists what changed since the products were put into the cart.
+Acknowledge is a digest of the changes, the client sends it back to say
+"I have seen these changes, place the order anyway".
+a new change gives a new digest, so an old acknowledgement doesn't cover it
+*/
+type CartChanges struct {
+	Price_Changes []PriceChange     `json:"price_changes"`
+	Unavailable   []UnavailableItem `json:"unavailable"`
+	Acknowledge   string            `json:"acknowledge"`
+}
+
+func (changes CartChanges) Empty() bool {
+	return len(changes.Price_Changes) == 0 && len(changes.Unavailable) == 0
+}
+
+// CartChangedError is returned by the checkout when the cart changed and it wasn't acknowledged
+type CartChangedError struct {
+	Changes CartChanges
+}
+
+func (err *CartChangedError) Error() string {
+	return "the cart changed since the products were added"
+}
+
+/*
+CheckCart compares every cart line with the product as it is now.
+it returns the lines that can be bought at the current prices and the changes,
+lines whose product is gone or out of stock are left out and lines with more pieces
+than the stock are cut down to the stock
+*/
+func CheckCart(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) ([]models.ProductUser, CartChanges, error) {
+	changes := CartChanges{Price_Changes: make([]PriceChange, 0), Unavailable: make([]UnavailableItem, 0)}
+	ids := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Product_ID)
+	}
+	cursor, err := prodCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		logError(ctx, err)
+		return nil, changes, ErrCantFindProduct
+	}
+	var products []models.Product
+	if err = cursor.All(ctx, &products); err != nil {
+		logError(ctx, err)
+		return nil, changes, ErrCantDecodeProducts
+	}
+	current := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		current[product.Product_ID] = product
+	}
+
+	buyable := make([]models.ProductUser, 0, len(items))
+	for _, item := range items {
+		product, ok := current[item.Product_ID]
+		if !ok || product.Price == nil {
+			changes.Unavailable = append(changes.Unavailable, UnavailableItem{
+				Product_ID: item.Product_ID, Product_Name: item.Product_Name, Reason: ReasonRemoved, Quantity: item.Qty(),
+			})
+			continue
+		}
+		if *product.Price != item.Price {
+			changes.Price_Changes = append(changes.Price_Changes, PriceChange{
+				Product_ID: item.Product_ID, Product_Name: item.Product_Name, Cart_Price: item.Price, Price: *product.Price,
+			})
+			item.Price = *product.Price
+		}
+		//a product without a stock is not counted
+		if product.Stock != nil && *product.Stock < item.Qty() {
+			available := *product.Stock
+			if available < 0 {
+				available = 0
+			}
+			changes.Unavailable = append(changes.Unavailable, UnavailableItem{
+				Product_ID: item.Product_ID, Product_Name: item.Product_Name, Reason: ReasonOutOfStock, Quantity: item.Qty(), Available: available,
+			})
+			if available == 0 {
+				continue
+			}
+			item.Quantity = *product.Stock
+		}
+		buyable = append(buyable, item)
+	}
+	changes.Acknowledge = changes.digest()
+	return buyable, changes, nil
+}
+
+func (changes CartChanges) digest() string {
+	if changes.Empty() {
+		return ""
+	}
+	hash := sha256.New()
+	for _, change := range changes.Price_Changes {
+		fmt.Fprintf(hash, "price:%s:%s:%s\n", change.Product_ID.Hex(), change.Cart_Price, change.Price)
+	}
+	for _, item := range changes.Unavailable {
+		fmt.Fprintf(hash, "unavailable:%s:%s:%d:%d\n", item.Product_ID.Hex(), item.Reason, item.Quantity, item.Available)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+TakeStock lowers the stock of the bought products.
+a product is only changed while it still has enough pieces, if one of them ran out
+in the meantime the products that were already lowered get their pieces back
+*/
+func TakeStock(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) error {
+	taken := make([]models.ProductUser, 0, len(items))
+	for _, item := range items {
+		//the filter only matches while there are enough pieces left
+		filter := bson.M{"_id": item.Product_ID, "stock": bson.M{"$gte": item.Qty()}}
+		update := bson.M{"$inc": bson.M{"stock": -item.Qty()}}
+		result, err := prodCollection.UpdateOne(ctx, filter, update)
+		if err == nil && result.MatchedCount == 1 {
+			taken = append(taken, item)
+			continue
+		}
+		if err == nil {
+			//nothing matched: either the stock isn't tracked or it ran out
+			var product models.Product
+			err = prodCollection.FindOne(ctx, bson.M{"_id": item.Product_ID}).Decode(&product)
+			if err == nil && product.Stock == nil {
+				continue
+			}
+			if err == nil {
+				err = ErrOutOfStock
+			}
+		}
+		ReturnStock(ctx, prodCollection, taken)
+		if err == ErrOutOfStock {
+			return err
+		}
+		logError(ctx, err)
+		return ErrCantUpdateStock
+	}
+	return nil
+}
+
+// ReturnStock gives the pieces taken by TakeStock back
+func ReturnStock(ctx context.Context, prodCollection *mongo.Collection, items []models.ProductUser) {
+	for _, item := range items {
+		//a null stock isn't counted, $inc would fail on it
+		filter := bson.M{"_id": item.Product_ID, "stock": bson.M{"$type": "number"}}
+		_, err := prodCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"stock": item.Qty()}})
+		if err != nil {
+			logError(ctx, err)
+		}
+	}
+}
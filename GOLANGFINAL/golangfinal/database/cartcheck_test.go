@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCartChangesDigest(t *testing.T) {
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	price := CartChanges{Price_Changes: []PriceChange{
+		{Product_ID: first, Cart_Price: models.NewMoney(1000, "USD"), Price: models.NewMoney(1200, "USD")},
+	}}
+	if (CartChanges{}).digest() != "" {
+		t.Error("no changes give a digest")
+	}
+	digest := price.digest()
+	if len(digest) != 64 {
+		t.Fatalf("digest = %q, want 64 hex digits", digest)
+	}
+	if price.digest() != digest {
+		t.Error("the same changes give another digest")
+	}
+	//every part of a change is in the digest, an acknowledgement only covers what the client saw
+	others := []CartChanges{
+		{Price_Changes: []PriceChange{{Product_ID: second, Cart_Price: models.NewMoney(1000, "USD"), Price: models.NewMoney(1200, "USD")}}},
+		{Price_Changes: []PriceChange{{Product_ID: first, Cart_Price: models.NewMoney(1000, "USD"), Price: models.NewMoney(1300, "USD")}}},
+		{Price_Changes: []PriceChange{{Product_ID: first, Cart_Price: models.NewMoney(1000, "USD"), Price: models.NewMoney(1200, "EUR")}}},
+		{Price_Changes: price.Price_Changes, Unavailable: []UnavailableItem{{Product_ID: second, Reason: ReasonRemoved, Quantity: 1}}},
+		{Unavailable: []UnavailableItem{{Product_ID: first, Reason: ReasonOutOfStock, Quantity: 3, Available: 1}}},
+		{Unavailable: []UnavailableItem{{Product_ID: first, Reason: ReasonOutOfStock, Quantity: 3, Available: 2}}},
+		{Unavailable: []UnavailableItem{{Product_ID: first, Reason: ReasonOutOfStock, Quantity: 4, Available: 1}}},
+	}
+	seen := map[string]int{digest: -1}
+	for i, other := range others {
+		if index, found := seen[other.digest()]; found {
+			t.Errorf("changes %d have the same digest as %d", i, index)
+		}
+		seen[other.digest()] = i
+	}
+	//the product names are shown to the client but don't change what was acknowledged
+	name := "renamed"
+	renamed := price
+	renamed.Price_Changes = []PriceChange{price.Price_Changes[0]}
+	renamed.Price_Changes[0].Product_Name = &name
+	if renamed.digest() != digest {
+		t.Error("the product name changes the digest")
+	}
+}
+
+func TestCheckCart(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("changes", func(mt *mtest.T) {
+		same, cheaper, fewer, gone, soldOut, untracked := primitive.NewObjectID(), primitive.NewObjectID(),
+			primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "shop.Products", mtest.FirstBatch,
+			productDoc(mt, same, 1000, intPtr(10)),
+			productDoc(mt, cheaper, 800, intPtr(10)),
+			productDoc(mt, fewer, 500, intPtr(2)),
+			productDoc(mt, soldOut, 500, intPtr(0)),
+			productDoc(mt, untracked, 300, nil),
+		))
+		items := []models.ProductUser{
+			{Product_ID: same, Price: models.NewMoney(1000, "USD"), Quantity: 1},
+			{Product_ID: cheaper, Price: models.NewMoney(900, "USD"), Quantity: 1},
+			{Product_ID: fewer, Price: models.NewMoney(500, "USD"), Quantity: 5},
+			{Product_ID: gone, Price: models.NewMoney(100, "USD")},
+			{Product_ID: soldOut, Price: models.NewMoney(500, "USD"), Quantity: 1},
+			{Product_ID: untracked, Price: models.NewMoney(300, "USD"), Quantity: 50},
+		}
+		buyable, changes, err := CheckCart(context.Background(), mt.Coll, items)
+		if err != nil {
+			mt.Fatal(err)
+		}
+		want := map[primitive.ObjectID]models.ProductUser{
+			same:      {Product_ID: same, Price: models.NewMoney(1000, "USD"), Quantity: 1},
+			cheaper:   {Product_ID: cheaper, Price: models.NewMoney(800, "USD"), Quantity: 1},
+			fewer:     {Product_ID: fewer, Price: models.NewMoney(500, "USD"), Quantity: 2},
+			untracked: {Product_ID: untracked, Price: models.NewMoney(300, "USD"), Quantity: 50},
+		}
+		if len(buyable) != len(want) {
+			mt.Errorf("%d buyable lines, want %d: %+v", len(buyable), len(want), buyable)
+		}
+		for _, item := range buyable {
+			if w, found := want[item.Product_ID]; !found || item.Price != w.Price || item.Quantity != w.Quantity {
+				mt.Errorf("buyable line %+v, want %+v", item, w)
+			}
+		}
+		if len(changes.Price_Changes) != 1 || changes.Price_Changes[0].Product_ID != cheaper ||
+			changes.Price_Changes[0].Cart_Price != models.NewMoney(900, "USD") || changes.Price_Changes[0].Price != models.NewMoney(800, "USD") {
+			mt.Errorf("price changes = %+v", changes.Price_Changes)
+		}
+		wantUnavailable := []UnavailableItem{
+			{Product_ID: fewer, Reason: ReasonOutOfStock, Quantity: 5, Available: 2},
+			{Product_ID: gone, Reason: ReasonRemoved, Quantity: 1},
+			{Product_ID: soldOut, Reason: ReasonOutOfStock, Quantity: 1, Available: 0},
+		}
+		if len(changes.Unavailable) != len(wantUnavailable) {
+			mt.Fatalf("unavailable = %+v, want %+v", changes.Unavailable, wantUnavailable)
+		}
+		for i, item := range changes.Unavailable {
+			if item != wantUnavailable[i] {
+				mt.Errorf("unavailable %d = %+v, want %+v", i, item, wantUnavailable[i])
+			}
+		}
+		if changes.Acknowledge == "" || changes.Acknowledge != changes.digest() {
+			mt.Errorf("acknowledge = %q, want the digest of the changes", changes.Acknowledge)
+		}
+	})
+
+	mt.Run("unchanged", func(mt *mtest.T) {
+		id := primitive.NewObjectID()
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "shop.Products", mtest.FirstBatch, productDoc(mt, id, 1000, intPtr(3))))
+		buyable, changes, err := CheckCart(context.Background(), mt.Coll, []models.ProductUser{
+			{Product_ID: id, Price: models.NewMoney(1000, "USD"), Quantity: 3},
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if len(buyable) != 1 || !changes.Empty() || changes.Acknowledge != "" {
+			mt.Errorf("buyable = %+v, changes = %+v, want the line and no changes", buyable, changes)
+		}
+	})
+}
+
+// productDoc is a product as the database returns it
+func productDoc(mt *mtest.T, id primitive.ObjectID, price int64, stock *int) bson.D {
+	mt.Helper()
+	money := models.NewMoney(price, "USD")
+	data, err := bson.Marshal(models.Product{Product_ID: id, Price: &money, Stock: stock})
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		mt.Fatal(err)
+	}
+	return doc
+}
+
+func intPtr(n int) *int {
+	return &n
+}
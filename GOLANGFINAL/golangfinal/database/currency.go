@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrUnknownCurrency   = errors.New("currency is not offered by the store")
+	ErrCantFindRates     = errors.New("can't find exchange rates")
+	ErrCantConvertPrices = errors.New("cannot convert the prices")
+)
+
+// GetExchangeRate returns the rate the admin set for the currency,
+// an empty currency or the base currency itself needs no conversion
+func GetExchangeRate(ctx context.Context, rateCollection *mongo.Collection, currency string) (models.ExchangeRate, error) {
+	if currency == "" || currency == models.DefaultCurrency {
+		return models.BaseRate(), nil
+	}
+	var rate models.ExchangeRate
+	err := rateCollection.FindOne(ctx, bson.M{"_id": currency}).Decode(&rate)
+	if err == mongo.ErrNoDocuments {
+		return rate, ErrUnknownCurrency
+	}
+	if err != nil {
+		logError(ctx, err)
+		return rate, ErrCantFindRates
+	}
+	return rate, nil
+}
+
+// GetExchangeRates returns the whole rate table, the base currency first
+func GetExchangeRates(ctx context.Context, rateCollection *mongo.Collection) ([]models.ExchangeRate, error) {
+	cursor, err := rateCollection.Find(ctx, bson.D{{}})
+	if err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindRates
+	}
+	defer cursor.Close(ctx)
+	rates := []models.ExchangeRate{models.BaseRate()}
+	for cursor.Next(ctx) {
+		var rate models.ExchangeRate
+		if err = cursor.Decode(&rate); err != nil {
+			logError(ctx, err)
+			return nil, ErrCantFindRates
+		}
+		rates = append(rates, rate)
+	}
+	if err = cursor.Err(); err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindRates
+	}
+	return rates, nil
+}
+
+// DisplayProducts fills the display price of every product in the currency of the rate
+func DisplayProducts(rate models.ExchangeRate, products []models.Product) error {
+	for i := range products {
+		if products[i].Price == nil {
+			continue
+		}
+		converted, err := rate.Convert(*products[i].Price)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCantConvertPrices, err)
+		}
+		products[i].Display_Price = &converted
+	}
+	return nil
+}
+
+// DisplayCart fills the display price of every cart line in the currency of the rate
+func DisplayCart(rate models.ExchangeRate, items []models.ProductUser) error {
+	for i := range items {
+		converted, err := rate.Convert(items[i].Price)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCantConvertPrices, err)
+		}
+		items[i].Display_Price = &converted
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantFindGuestCart = errors.New("can't find the guest cart")
+	ErrCantMergeCart     = errors.New("cannot merge the guest cart")
+)
+
+/*
+GuestCartLifetime is how long a guest cart is kept after a product was last added, the same as
+the lifetime of the cart token. every product added gives a new token, the cart lives as long
+as the newest one and can be deleted once expires_at passed
+*/
+var GuestCartLifetime = 720 * time.Hour
+
+func CreateGuestCart(ctx context.Context, guestCollection *mongo.Collection) (models.GuestCart, error) {
+	now := time.Now()
+	cart := models.GuestCart{
+		Cart_ID:    primitive.NewObjectID(),
+		Items:      make([]models.ProductUser, 0),
+		Created_At: now,
+		Updated_At: now,
+		Expires_At: now.Add(GuestCartLifetime),
+	}
+	_, err := guestCollection.InsertOne(ctx, cart)
+	if err != nil {
+		logError(ctx, err)
+		return cart, ErrCantUpdateUser
+	}
+	return cart, nil
+}
+
+func GetGuestCart(ctx context.Context, guestCollection *mongo.Collection, cartID string) (models.GuestCart, error) {
+	var cart models.GuestCart
+	id, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return cart, ErrCantFindGuestCart
+	}
+	err = guestCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&cart)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			logError(ctx, err)
+		}
+		return cart, ErrCantFindGuestCart
+	}
+	return cart, nil
+}
+
+// AddProductToGuestCart works like AddProductToCart for a visitor
+func AddProductToGuestCart(ctx context.Context, prodCollection, guestCollection *mongo.Collection, productID primitive.ObjectID, cartID string) error {
+	var product models.ProductUser
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantFindProduct
+	}
+	id, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return ErrCantFindGuestCart
+	}
+	err = addCartLine(ctx, guestCollection, id, product, 1)
+	if err == mongo.ErrNoDocuments {
+		return ErrCantFindGuestCart
+	}
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateUser
+	}
+	now := time.Now()
+	_, err = guestCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"updated_at": now, "expires_at": now.Add(GuestCartLifetime)}})
+	if err != nil {
+		logError(ctx, err)
+	}
+	return nil
+}
+
+func RemoveGuestCartItem(ctx context.Context, guestCollection *mongo.Collection, productID primitive.ObjectID, cartID string) error {
+	id, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return ErrCantFindGuestCart
+	}
+	update := bson.M{
+		"$pull": bson.M{"usercart": bson.M{"_id": productID}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+	result, err := guestCollection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantRemoveItem
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindGuestCart
+	}
+	return nil
+}
+
+/*
+MergeGuestCart moves the cart of the visitor into the cart of the user who just logged in.
+the guest cart is taken out of the collection first, so two logins with the same token can't
+both merge it. every line is added the way AddToCart adds it, a product the user adds at the
+same time isn't overwritten
+*/
+func MergeGuestCart(ctx context.Context, userCollection, guestCollection *mongo.Collection, cartID string, userID string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	count, err := userCollection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	if count == 0 {
+		return ErrUserIDIsNotValid
+	}
+	guestID, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return ErrCantFindGuestCart
+	}
+	var guest models.GuestCart
+	err = guestCollection.FindOneAndDelete(ctx, bson.M{"_id": guestID}).Decode(&guest)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			logError(ctx, err)
+		}
+		return ErrCantFindGuestCart
+	}
+	for i, item := range guest.Items {
+		if err = addCartLine(ctx, userCollection, id, item, item.Qty()); err != nil {
+			logError(ctx, err)
+			//the lines that weren't merged go back into the guest cart, the next login tries again
+			guest.Items = guest.Items[i:]
+			if _, err = guestCollection.InsertOne(ctx, guest); err != nil {
+				logError(ctx, err)
+			}
+			return ErrCantMergeCart
+		}
+	}
+	return nil
+}
+
+// DeleteGuestCart deletes the guest cart, a cart that is already gone is not an error
+func DeleteGuestCart(ctx context.Context, guestCollection *mongo.Collection, cartID string) error {
+	id, err := primitive.ObjectIDFromHex(cartID)
+	if err != nil {
+		return ErrCantFindGuestCart
+	}
+	if _, err = guestCollection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		logError(ctx, err)
+		return ErrCantDeleteAccount
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("the idempotency key was used for a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+	ErrCantSaveIdempotencyKey   = errors.New("cannot save the idempotency key")
+)
+
+/*
+IdempotencyKeyLifetime is how long a key is remembered, after that it can be used again.
+the saved responses are kept for that long too, the TTL index of created_at deletes them
+afterwards. mongodb removes expired documents about once a minute, until then an old key
+is replaced like before
+*/
+const IdempotencyKeyLifetime = 24 * time.Hour
+
+/*
+StartIdempotentRequest claims the key for the request with the fingerprint.
+when the key is new it returns nil and the request can run.
+when the same request already finished it returns the saved response to replay,
+a different request with the same key or a request that is still running gives an error
+*/
+func StartIdempotentRequest(ctx context.Context, idempotencyCollection *mongo.Collection, keyID string, fingerprint string) (*models.IdempotencyKey, error) {
+	record := models.IdempotencyKey{Key_ID: keyID, Fingerprint: fingerprint, Created_At: time.Now()}
+	//the _id is unique, so only one of two requests with the same key can insert it
+	_, err := idempotencyCollection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		logError(ctx, err)
+		return nil, ErrCantSaveIdempotencyKey
+	}
+	var saved models.IdempotencyKey
+	if err = idempotencyCollection.FindOne(ctx, bson.M{"_id": keyID}).Decode(&saved); err != nil {
+		logError(ctx, err)
+		return nil, ErrCantSaveIdempotencyKey
+	}
+	//an expired key is forgotten and the request runs again
+	if time.Since(saved.Created_At) > IdempotencyKeyLifetime {
+		result, err := idempotencyCollection.ReplaceOne(ctx, bson.M{"_id": keyID, "created_at": saved.Created_At}, record)
+		if err != nil {
+			logError(ctx, err)
+			return nil, ErrCantSaveIdempotencyKey
+		}
+		if result.MatchedCount == 1 {
+			return nil, nil
+		}
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	if saved.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !saved.Completed {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+	return &saved, nil
+}
+
+// CompleteIdempotentRequest saves the response so the key can replay it
+func CompleteIdempotentRequest(ctx context.Context, idempotencyCollection *mongo.Collection, keyID string, status int, contentType string, body []byte) error {
+	update := bson.M{"$set": bson.M{"completed": true, "status": status, "content_type": contentType, "body": body}}
+	_, err := idempotencyCollection.UpdateOne(ctx, bson.M{"_id": keyID}, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantSaveIdempotencyKey
+	}
+	return nil
+}
+
+// ForgetIdempotentRequest frees the key, used when the request failed on our side and may be retried
+func ForgetIdempotentRequest(ctx context.Context, idempotencyCollection *mongo.Collection, keyID string) error {
+	_, err := idempotencyCollection.DeleteOne(ctx, bson.M{"_id": keyID, "completed": false})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantSaveIdempotencyKey
+	}
+	return nil
+}
+
+// DeleteIdempotencyKeys deletes the keys of the user and the responses saved with them, the ids start with the user id
+func DeleteIdempotencyKeys(ctx context.Context, idempotencyCollection *mongo.Collection, userID string) error {
+	filter := bson.M{"_id": bson.M{"$regex": "^" + regexp.QuoteMeta(userID+":")}}
+	if _, err := idempotencyCollection.DeleteMany(ctx, filter); err != nil {
+		logError(ctx, err)
+		return ErrCantSaveIdempotencyKey
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrCantLinkIdentity = errors.New("cannot link the login provider to the account")
+
+// FindUserByIdentity finds the user linked to the account at the provider
+func FindUserByIdentity(ctx context.Context, userCollection *mongo.Collection, provider string, subject string) (models.User, error) {
+	var user models.User
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	err := userCollection.FindOne(ctx, filter).Decode(&user)
+	return user, err
+}
+
+/*
+LinkIdentity links the account at the provider to the user.
+an account that never verified its email could have been signed up by someone else
+with this address, so it is taken over: the email counts as verified and the password is removed
+*/
+func LinkIdentity(ctx context.Context, userCollection *mongo.Collection, userID string, identity models.Identity, takeOver bool) error {
+	update := bson.M{"$push": bson.M{"identities": identity}}
+	if takeOver {
+		update["$set"] = bson.M{"email_verified": true, "verified_at": time.Now()}
+		update["$unset"] = bson.M{"password": ""}
+	}
+	filter := bson.M{"user_id": userID, "identities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"provider": identity.Provider}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantLinkIdentity
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantLinkIdentity
+	}
+	return nil
+}
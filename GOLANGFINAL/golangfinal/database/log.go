@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+logError writes the error behind a failed operation before it is replaced by one of the Err values.
+the line names the function it came from and carries the fields of ctx, like the request id and
+the user. a document that isn't there is usually a bad id from the client, so it is only a warning
+*/
+func logError(ctx context.Context, err error) {
+	level := slog.LevelError
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		level = slog.LevelWarn
+	}
+	op := "unknown"
+	if pc, _, _, ok := runtime.Caller(1); ok {
+		//golangfinal/database.AddProductToCart becomes AddProductToCart, closures keep their .func1
+		name := runtime.FuncForPC(pc).Name()
+		name = name[strings.LastIndex(name, "/")+1:]
+		op = name[strings.Index(name, ".")+1:]
+	}
+	slog.Log(ctx, level, "database operation failed", "op", op, "error", err)
+}
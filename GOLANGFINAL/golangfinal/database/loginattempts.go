@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrLoginLocked       = errors.New("too many failed logins")
+	ErrCantRecordAttempt = errors.New("cannot record the login attempt")
+)
+
+/*
+an account is locked after AccountFailureLimit failed logins in a row and an ip address
+after IPFailureLimit, first for LockoutBase and then twice as long with every further failure,
+up to LockoutMax. failures older than FailureWindow are forgotten, the TTL index of last_failure
+deletes the record then. LockoutMax is shorter, so no lock is still running at that point
+*/
+const (
+	AccountFailureLimit = 5
+	IPFailureLimit      = 20
+	LockoutBase         = time.Minute
+	LockoutMax          = time.Hour
+	FailureWindow       = 24 * time.Hour
+)
+
+// AccountAttemptKey and IPAttemptKey are the ids of the login attempt records
+func AccountAttemptKey(email string) string {
+	return "account:" + NormalizeEmail(email)
+}
+
+func IPAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+// CheckLoginAllowed returns ErrLoginLocked while one of the keys is locked
+func CheckLoginAllowed(ctx context.Context, attemptCollection *mongo.Collection, keys ...string) error {
+	filter := bson.M{"_id": bson.M{"$in": keys}, "locked_until": bson.M{"$gt": time.Now()}}
+	count, err := attemptCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantRecordAttempt
+	}
+	if count > 0 {
+		return ErrLoginLocked
+	}
+	return nil
+}
+
+// RecordLoginFailure counts a failed login for the key and locks it when it is over the limit
+func RecordLoginFailure(ctx context.Context, attemptCollection *mongo.Collection, key string, limit int) error {
+	now := time.Now()
+	//a pipeline update, so an old streak starts again from 1 in the same step
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$lt": bson.A{"$last_failure", now.Add(-FailureWindow)}},
+			1,
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+		}},
+		"last_failure": now,
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var attempt models.LoginAttempt
+	if err := attemptCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt); err != nil {
+		logError(ctx, err)
+		return ErrCantRecordAttempt
+	}
+	if attempt.Failures < limit {
+		return nil
+	}
+	lockout := lockoutFor(attempt.Failures - limit)
+	_, err := attemptCollection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"locked_until": now.Add(lockout)}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantRecordAttempt
+	}
+	return nil
+}
+
+func lockoutFor(extra int) time.Duration {
+	lockout := LockoutBase
+	for i := 0; i < extra && lockout < LockoutMax; i++ {
+		lockout *= 2
+	}
+	if lockout > LockoutMax {
+		lockout = LockoutMax
+	}
+	return lockout
+}
+
+// ClearLoginFailures forgets the failures of the key, after a good login or when an admin unlocks it
+func ClearLoginFailures(ctx context.Context, attemptCollection *mongo.Collection, key string) (bool, error) {
+	result, err := attemptCollection.DeleteOne(ctx, bson.M{"_id": key})
+	if err != nil {
+		logError(ctx, err)
+		return false, ErrCantRecordAttempt
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// ForgetLoginAttempts deletes the records of the keys, the ones that don't exist are skipped
+func ForgetLoginAttempts(ctx context.Context, attemptCollection *mongo.Collection, keys ...string) error {
+	if _, err := attemptCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": keys}}); err != nil {
+		logError(ctx, err)
+		return ErrCantRecordAttempt
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// legacy prices are bare numbers instead of {amount, currency} documents
+var legacyPrice = bson.M{"$type": "number"}
+
+/*
+MigrateMoney rewrites the prices that were saved as bare ints into money documents.
+the old ints were whole units of models.DefaultCurrency.
+models.Money can still read the old ints, so the documents are just decoded
+and the price fields are written back, running it twice changes nothing
+*/
+func MigrateMoney(ctx context.Context, prodCollection, userCollection *mongo.Collection) error {
+	products, err := prodCollection.Find(ctx, bson.M{"price": legacyPrice})
+	if err != nil {
+		return err
+	}
+	defer products.Close(ctx)
+	migrated := 0
+	for products.Next(ctx) {
+		var product models.Product
+		if err = products.Decode(&product); err != nil {
+			return err
+		}
+		_, err = prodCollection.UpdateOne(ctx, bson.M{"_id": product.Product_ID}, bson.M{"$set": bson.M{"price": product.Price}})
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err = products.Err(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "migrated the prices of the products", "count", migrated)
+
+	users, err := userCollection.Find(ctx, bson.M{"$or": []bson.M{
+		{"usercart.price": legacyPrice},
+		{"orders.total_price": legacyPrice},
+		{"orders.discount": legacyPrice},
+		{"orders.order_list.price": legacyPrice},
+	}})
+	if err != nil {
+		return err
+	}
+	defer users.Close(ctx)
+	migrated = 0
+	for users.Next(ctx) {
+		var user models.User
+		if err = users.Decode(&user); err != nil {
+			return err
+		}
+		update := bson.M{"$set": bson.M{"usercart": user.UserCart, "orders": user.Order_Status}}
+		_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, update)
+		if err != nil {
+			return err
+		}
+		migrated++
+	}
+	if err = users.Err(); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "migrated the carts and orders of the users", "count", migrated)
+	return nil
+}
+
+// MigrateCartQuantities gives the cart lines saved before the quantity existed a quantity of 1,
+// otherwise adding the same product again would raise the missing quantity to 1 instead of 2
+func MigrateCartQuantities(ctx context.Context, userCollection *mongo.Collection) error {
+	//a missing quantity and a quantity of 0 are both old lines
+	noQuantity := bson.M{"$not": bson.M{"$gte": 1}}
+	filter := bson.M{"usercart": bson.M{"$elemMatch": bson.M{"quantity": noQuantity}}}
+	update := bson.M{"$set": bson.M{"usercart.$[line].quantity": 1}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{
+		bson.M{"line.quantity": noQuantity},
+	}})
+	result, err := userCollection.UpdateMany(ctx, filter, update, opts)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "migrated the cart quantities of the users", "count", result.ModifiedCount)
+	return nil
+}
+
+// MigrateEmailVerified marks the users that signed up before the email verification as verified
+func MigrateEmailVerified(ctx context.Context, userCollection *mongo.Collection) error {
+	filter := bson.M{"email_verified": bson.M{"$exists": false}}
+	result, err := userCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"email_verified": true}})
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "marked the existing users as verified", "count", result.ModifiedCount)
+	return nil
+}
+
+/*
+MigrateEmailCase saves the emails in lower case, the way NormalizeEmail looks them up.
+two accounts that only differ in the case of the email stop it with a duplicate key error
+on the email index, one of them has to be changed by hand before it can run
+*/
+func MigrateEmailCase(ctx context.Context, userCollection *mongo.Collection) error {
+	filter := bson.M{
+		"email": bson.M{"$type": "string"},
+		"$expr": bson.M{"$ne": bson.A{"$email", bson.M{"$toLower": "$email"}}},
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"email": bson.M{"$toLower": "$email"}}}}}
+	result, err := userCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "saved the emails in lower case", "count", result.ModifiedCount)
+	return nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrCantUpdatePassword = errors.New("cannot update the password")
+
+// PurposeResetPassword is the verification token sent by "forgot password"
+const PurposeResetPassword = "reset_password"
+
+// ResetTokenLifetime is how long a password reset link works, main sets it from the configuration
+var ResetTokenLifetime = time.Hour
+
+/*
+SetPassword saves the new password hash and raises the token version,
+so a login that is waiting for its second factor has to start again.
+the sessions are logged out with RevokeSessions
+*/
+func SetPassword(ctx context.Context, userCollection *mongo.Collection, userID string, hashedPassword string) (int, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, ErrUserIDIsNotValid
+	}
+	update := bson.M{
+		"$set": bson.M{"password": hashedPassword, "updated_at": time.Now(), "token": nil, "refresh_token": nil},
+		"$inc": bson.M{"token_version": 1},
+	}
+	var user struct {
+		Token_Version int `bson:"token_version"`
+	}
+	err = userCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+	if err != nil {
+		logError(ctx, err)
+		return 0, ErrCantUpdatePassword
+	}
+	return user.Token_Version, nil
+}
+
+// UpdatePasswordHash replaces the hash of the same password with one made under the current policy, the tokens stay valid
+func UpdatePasswordHash(ctx context.Context, userCollection *mongo.Collection, userID string, oldHash string, newHash string) error {
+	//only while the old hash is still there, a password change in the meantime wins
+	_, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID, "password": oldHash}, bson.M{"$set": bson.M{"password": newHash}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdatePassword
+	}
+	return nil
+}
+
+// GrantScope gives the user with the email a scope, it is in the tokens of the next login
+func GrantScope(ctx context.Context, userCollection *mongo.Collection, email string, scope string) error {
+	result, err := userCollection.UpdateOne(ctx, bson.M{"email": NormalizeEmail(email)}, bson.M{"$addToSet": bson.M{"scopes": scope}})
+	if err != nil {
+		logError(ctx, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserIDIsNotValid
+	}
+	return nil
+}
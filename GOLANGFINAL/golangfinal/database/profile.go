@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantUpdateProfile = errors.New("cannot update the profile")
+	ErrPhoneInUse        = errors.New("phone is already in use")
+	ErrEmailInUse        = errors.New("email is already in use")
+)
+
+// PurposeChangeEmail is the verification token sent to the new address of a change of email
+const PurposeChangeEmail = "change_email"
+
+// ProfileUpdate holds the fields of the profile that are changed, nil ones stay as they are
+type ProfileUpdate struct {
+	First_Name *string
+	Last_Name  *string
+	Phone      *string
+}
+
+/*
+UpdateProfile saves the changed names and phone of the user and returns the updated user.
+a new phone number has to be free
+*/
+func UpdateProfile(ctx context.Context, userCollection *mongo.Collection, userID string, update ProfileUpdate) (models.User, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, ErrUserIDIsNotValid
+	}
+	if err != nil {
+		logError(ctx, err)
+		return user, ErrCantUpdateProfile
+	}
+	set := bson.M{"updated_at": time.Now()}
+	if update.First_Name != nil {
+		set["first_name"] = *update.First_Name
+	}
+	if update.Last_Name != nil {
+		set["last_name"] = *update.Last_Name
+	}
+	if update.Phone != nil && (user.Phone == nil || *user.Phone != *update.Phone) {
+		count, err := userCollection.CountDocuments(ctx, bson.M{"phone": *update.Phone, "user_id": bson.M{"$ne": userID}})
+		if err != nil {
+			logError(ctx, err)
+			return user, ErrCantUpdateProfile
+		}
+		if count > 0 {
+			return user, ErrPhoneInUse
+		}
+		set["phone"] = *update.Phone
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err = userCollection.FindOneAndUpdate(ctx, bson.M{"user_id": userID}, bson.M{"$set": set}, opts).Decode(&user)
+	if IsDuplicate(err, IndexUserPhone) {
+		return user, ErrPhoneInUse
+	}
+	if err != nil {
+		logError(ctx, err)
+		return user, ErrCantUpdateProfile
+	}
+	return user, nil
+}
+
+// SetPendingEmail remembers the address the user wants to change to, until the link sent there is followed
+func SetPendingEmail(ctx context.Context, userCollection *mongo.Collection, userID string, email string) error {
+	result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"pending_email": email, "updated_at": time.Now()}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateProfile
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserIDIsNotValid
+	}
+	return nil
+}
+
+// NormalizeEmail is the form an email is saved and looked up in, mongodb compares strings case sensitive
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+/*
+ChangeEmail makes the pending address the email of the user, it counts as verified because
+the link was followed. it only works while the address is still the pending one and no other
+account took it in the meantime
+*/
+func ChangeEmail(ctx context.Context, userCollection *mongo.Collection, userID string, email string) error {
+	count, err := userCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateProfile
+	}
+	if count > 0 {
+		return ErrEmailInUse
+	}
+	now := time.Now()
+	update := bson.M{
+		"$set":   bson.M{"email": email, "email_verified": true, "verified_at": now, "updated_at": now},
+		"$unset": bson.M{"pending_email": ""},
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID, "pending_email": email}, update)
+	if IsDuplicate(err, IndexUserEmail) {
+		return ErrEmailInUse
+	}
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateProfile
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the names of the unique indexes, a duplicate key error names the index it came from
+const (
+	IndexUserEmail    = "users_email_unique"
+	IndexUserPhone    = "users_phone_unique"
+	IndexUserID       = "users_user_id_unique"
+	IndexUserIdentity = "users_identity_unique"
+	IndexTokenHash    = "verification_token_hash_unique"
+	IndexAPIKeyHash   = "apikeys_key_hash_unique"
+)
+
+// the collection that records which migrations ran
+const schemaMigrations = "SchemaMigrations"
+
+// only documents where the field is a string are indexed, so deleted users without an email or phone don't collide
+func onlyStrings(field string) *options.IndexOptions {
+	return options.Index().SetPartialFilterExpression(bson.M{field: bson.M{"$type": "string"}})
+}
+
+// indexes are the indexes of every collection, by the name of the collection
+var indexes = map[string][]mongo.IndexModel{
+	"Users": {
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: onlyStrings("email").SetUnique(true).SetName(IndexUserEmail)},
+		{Keys: bson.D{{Key: "phone", Value: 1}}, Options: onlyStrings("phone").SetUnique(true).SetName(IndexUserPhone)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true).SetName(IndexUserID)},
+		{Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}}, Options: onlyStrings("identities.subject").SetUnique(true).SetName(IndexUserIdentity)},
+		//not unique: the wishlists are an array, the wishlists without a token are indexed as null and
+		//two users with an unshared wishlist would collide. the tokens are 24 random bytes, they don't repeat
+		{Keys: bson.D{{Key: "wishlists.share_token", Value: 1}}, Options: onlyStrings("wishlists.share_token").SetName("users_share_token")},
+	},
+	//the search by name, the category and the price filter, and the reviews of a user
+	"Products": {
+		{Keys: bson.D{{Key: "product_name", Value: 1}}, Options: options.Index().SetName("products_name")},
+		{Keys: bson.D{{Key: "category", Value: 1}}, Options: options.Index().SetName("products_category")},
+		{Keys: bson.D{{Key: "price.currency", Value: 1}, {Key: "price.amount", Value: 1}}, Options: options.Index().SetName("products_price")},
+		{Keys: bson.D{{Key: "comment.user_id", Value: 1}}, Options: onlyStrings("comment.user_id").SetName("products_comment_user")},
+	},
+	"Sessions": {
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen", Value: -1}}, Options: options.Index().SetName("sessions_user")},
+		//a session is deleted once it expired, logged out ones stay until then
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0).SetName("sessions_expires_ttl")},
+	},
+	"LoginAttempts": {
+		{Keys: bson.D{{Key: "last_failure", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(FailureWindow.Seconds())).SetName("loginattempts_last_failure_ttl")},
+	},
+	"VerificationTokens": {
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetName(IndexTokenHash)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("verification_user_purpose")},
+	},
+	"IdempotencyKeys": {
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(IdempotencyKeyLifetime.Seconds())).SetName("idempotency_created_ttl")},
+	},
+	"APIKeys": {
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true).SetName(IndexAPIKeyHash)},
+	},
+	//mongodb deletes a guest cart once its expires_at passed
+	"GuestCarts": {
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0).SetName("guestcarts_expires_ttl")},
+	},
+}
+
+/*
+EnsureIndexes creates the indexes that are missing, the ones that exist are left alone.
+a unique index can't be built while the collection has duplicates, the error names them
+and they have to be cleaned up by hand
+*/
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	for collection, collectionIndexes := range indexes {
+		names, err := db.Collection(collection).Indexes().CreateMany(ctx, collectionIndexes)
+		if err != nil {
+			return fmt.Errorf("indexes of %s: %w", collection, err)
+		}
+		slog.InfoContext(ctx, "indexes created", "collection", collection, "indexes", names)
+	}
+	return nil
+}
+
+// IsDuplicate tells if the error is a duplicate key error of the unique index
+func IsDuplicate(err error, index string) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "index: "+index+" ")
+}
+
+// Migration is one change to the documents, it runs once per database, in the order of the versions
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+/*
+Migrations are every migration so far. a new one gets the next version and is never changed
+after it shipped. they can run twice when two servers start at once, so each one has to be
+safe to repeat
+*/
+var Migrations = []Migration{
+	{1, "prices as money documents", func(ctx context.Context, db *mongo.Database) error {
+		return MigrateMoney(ctx, db.Collection("Products"), db.Collection("Users"))
+	}},
+	{2, "cart quantities", func(ctx context.Context, db *mongo.Database) error {
+		return MigrateCartQuantities(ctx, db.Collection("Users"))
+	}},
+	{3, "existing emails verified", func(ctx context.Context, db *mongo.Database) error {
+		return MigrateEmailVerified(ctx, db.Collection("Users"))
+	}},
+	{4, "emails in lower case", func(ctx context.Context, db *mongo.Database) error {
+		return MigrateEmailCase(ctx, db.Collection("Users"))
+	}},
+}
+
+// appliedMigration is the record of a migration that ran, in the SchemaMigrations collection
+type appliedMigration struct {
+	Version    int       `bson:"_id"`
+	Name       string    `bson:"name"`
+	Applied_At time.Time `bson:"applied_at"`
+}
+
+// PendingMigrations are the migrations that didn't run on the database yet, in order
+func PendingMigrations(ctx context.Context, db *mongo.Database) ([]Migration, error) {
+	cursor, err := db.Collection(schemaMigrations).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err = cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool)
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+	var pending []Migration
+	for _, migration := range Migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate runs the migrations that didn't run on the database yet
+func Migrate(ctx context.Context, db *mongo.Database) error {
+	pending, err := PendingMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		slog.InfoContext(ctx, "running migration", "version", migration.Version, "name", migration.Name)
+		if err = migration.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+		record := appliedMigration{Version: migration.Version, Name: migration.Name, Applied_At: time.Now()}
+		_, err = db.Collection(schemaMigrations).InsertOne(ctx, record)
+		//another server finished it at the same time
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupSchema brings the database up to date: first the migrations, then the indexes
+func SetupSchema(ctx context.Context, db *mongo.Database) error {
+	if err := Migrate(ctx, db); err != nil {
+		return err
+	}
+	return EnsureIndexes(ctx, db)
+}
@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindSession   = errors.New("cannot find the session")
+	ErrCantCreateSession = errors.New("cannot create the session")
+	ErrSessionRevoked    = errors.New("the session was logged out, log in again")
+)
+
+// SessionLifetime is how long a login lasts, main sets it to the lifetime of the refresh token.
+// the TTL index of expires_at deletes the session from the database when it is over
+var SessionLifetime = 168 * time.Hour
+
+// last_seen is only written again after this long, so not every request writes to the database
+const lastSeenInterval = time.Minute
+
+// CreateSession saves a new login of the user
+func CreateSession(ctx context.Context, sessionCollection *mongo.Collection, userID string, userAgent string, ip string) (models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		Session_ID: primitive.NewObjectID(),
+		User_ID:    userID,
+		User_Agent: userAgent,
+		IP:         ip,
+		Created_At: now,
+		Last_Seen:  now,
+		Expires_At: now.Add(SessionLifetime),
+	}
+	if _, err := sessionCollection.InsertOne(ctx, session); err != nil {
+		logError(ctx, err)
+		return session, ErrCantCreateSession
+	}
+	return session, nil
+}
+
+// activeSession matches the sessions that weren't logged out and didn't expire
+func activeSession(filter bson.M) bson.M {
+	filter["revoked_at"] = nil
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	return filter
+}
+
+/*
+TouchSession checks that the session of a token is still active and notes the time it was used,
+ip is the address of the request
+*/
+func TouchSession(ctx context.Context, sessionCollection *mongo.Collection, sessionID string, userID string, ip string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionRevoked
+	}
+	var session models.Session
+	err = sessionCollection.FindOne(ctx, activeSession(bson.M{"_id": id, "user_id": userID})).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantFindSession
+	}
+	if time.Since(session.Last_Seen) > lastSeenInterval || session.IP != ip {
+		_, err = sessionCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_seen": time.Now(), "ip": ip}})
+		if err != nil {
+			logError(ctx, err)
+		}
+	}
+	return nil
+}
+
+// ListSessions returns the active sessions of the user, the last used first
+func ListSessions(ctx context.Context, sessionCollection *mongo.Collection, userID string) ([]models.Session, error) {
+	opts := options.Find().SetSort(bson.M{"last_seen": -1})
+	cursor, err := sessionCollection.Find(ctx, activeSession(bson.M{"user_id": userID}), opts)
+	if err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindSession
+	}
+	sessions := make([]models.Session, 0)
+	if err = cursor.All(ctx, &sessions); err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindSession
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs one session of the user out
+func RevokeSession(ctx context.Context, sessionCollection *mongo.Collection, userID string, sessionID string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrCantFindSession
+	}
+	result, err := sessionCollection.UpdateOne(ctx, activeSession(bson.M{"_id": id, "user_id": userID}), bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantFindSession
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindSession
+	}
+	return nil
+}
+
+// RevokeSessions logs every session of the user out except the one with the id except, which may be empty
+func RevokeSessions(ctx context.Context, sessionCollection *mongo.Collection, userID string, except string) error {
+	filter := activeSession(bson.M{"user_id": userID})
+	if id, err := primitive.ObjectIDFromHex(except); err == nil {
+		filter["_id"] = bson.M{"$ne": id}
+	}
+	_, err := sessionCollection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantFindSession
+	}
+	return nil
+}
+
+// SessionIPs are the addresses the sessions of the user were used from, the logged out ones too
+func SessionIPs(ctx context.Context, sessionCollection *mongo.Collection, userID string) ([]string, error) {
+	values, err := sessionCollection.Distinct(ctx, "ip", bson.M{"user_id": userID})
+	if err != nil {
+		logError(ctx, err)
+		return nil, err
+	}
+	ips := make([]string, 0, len(values))
+	for _, value := range values {
+		if ip, ok := value.(string); ok && ip != "" {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindTaxRules = errors.New("can't find tax rules")
+)
+
+// GetTaxRules returns every tax rule configured by the admin, the oldest first
+func GetTaxRules(ctx context.Context, taxCollection *mongo.Collection) ([]models.TaxRule, error) {
+	//ResolveTaxRule keeps the first of two equally specific rules, the order makes that the older one
+	cursor, err := taxCollection.Find(ctx, bson.D{{}}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindTaxRules
+	}
+	defer cursor.Close(ctx)
+	rules := make([]models.TaxRule, 0)
+	if err = cursor.All(ctx, &rules); err != nil {
+		logError(ctx, err)
+		return nil, ErrCantFindTaxRules
+	}
+	return rules, nil
+}
+
+// ShippingAddress returns the address the order is shipped to.
+// the home address (address.0) is used, nil if the user has no address yet
+func ShippingAddress(user models.User) *models.Address {
+	if len(user.Address_Details) == 0 {
+		return nil
+	}
+	return &user.Address_Details[0]
+}
+
+// ResolveTaxRule picks the most specific rule for the address and the category.
+// a rule that names a value has to match it, an empty value matches anything.
+// country+region+category beats country+region, which beats country alone etc.
+// of two rules that are just as specific the first one wins. nil means no tax applies
+func ResolveTaxRule(rules []models.TaxRule, address *models.Address, category *string) *models.TaxRule {
+	var country, region string
+	if address != nil {
+		country = value(address.Country)
+		region = value(address.Region)
+	}
+	var best *models.TaxRule
+	bestScore := -1
+	for i := range rules {
+		rule := &rules[i]
+		score := 0
+		//every field that matches exactly makes the rule more specific
+		for weight, pair := range [][2]string{{value(rule.Category), value(category)}, {value(rule.Region), region}, {value(rule.Country), country}} {
+			if pair[0] == "" {
+				continue
+			}
+			if !strings.EqualFold(pair[0], pair[1]) {
+				score = -1
+				break
+			}
+			score += 1 << weight
+		}
+		if score > bestScore {
+			best = rule
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// CalculateTax builds the per line tax breakdown for the items shipped to the address
+// it returns the lines, the total tax and the total amount the user has to pay
+// all the items have to be priced in the same currency
+func CalculateTax(rules []models.TaxRule, address *models.Address, items []models.ProductUser) ([]models.TaxLine, models.Money, models.Money, error) {
+	currency := models.DefaultCurrency
+	if len(items) > 0 {
+		currency = items[0].Price.Currency
+	}
+	lines := make([]models.TaxLine, 0, len(items))
+	totalTax := models.NewMoney(0, currency)
+	totalGross := models.NewMoney(0, currency)
+	for _, item := range items {
+		line, err := taxLine(ResolveTaxRule(rules, address, item.Category), item)
+		if err != nil {
+			return nil, models.Money{}, models.Money{}, err
+		}
+		if totalTax, err = totalTax.Add(line.Tax); err != nil {
+			return nil, models.Money{}, models.Money{}, err
+		}
+		if totalGross, err = totalGross.Add(line.Gross); err != nil {
+			return nil, models.Money{}, models.Money{}, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, totalTax, totalGross, nil
+}
+
+func taxLine(rule *models.TaxRule, item models.ProductUser) (models.TaxLine, error) {
+	//the tax is calculated on the whole line, not on a single piece
+	price, err := item.Price.Mul(int64(item.Qty()))
+	if err != nil {
+		return models.TaxLine{}, err
+	}
+	line := models.TaxLine{
+		Product_ID:   item.Product_ID,
+		Product_Name: item.Product_Name,
+		Quantity:     item.Qty(),
+		Net:          price,
+		Tax:          models.NewMoney(0, price.Currency),
+		Gross:        price,
+	}
+	if rule == nil {
+		return line, nil
+	}
+	line.Tax_Name = value(rule.Name)
+	line.Rate = rule.Rate
+	line.Inclusive = rule.Inclusive
+	if rule.Inclusive {
+		//the price already holds the tax, take it out of the price
+		if line.Net, err = price.MulRate(10000, int64(10000+rule.Rate)); err != nil {
+			return line, err
+		}
+		line.Tax, err = price.Sub(line.Net)
+		return line, err
+	}
+	if line.Tax, err = price.MulRate(int64(rule.Rate), 10000); err != nil {
+		return line, err
+	}
+	line.Gross, err = price.Add(line.Tax)
+	return line, err
+}
+
+func value(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"testing"
+
+	"golangfinal/models"
+)
+
+func str(s string) *string {
+	return &s
+}
+
+func rule(name, country, region, category string, rate int, inclusive bool) models.TaxRule {
+	taxRule := models.TaxRule{Name: str(name), Rate: rate, Inclusive: inclusive}
+	if country != "" {
+		taxRule.Country = str(country)
+	}
+	if region != "" {
+		taxRule.Region = str(region)
+	}
+	if category != "" {
+		taxRule.Category = str(category)
+	}
+	return taxRule
+}
+
+func TestResolveTaxRule(t *testing.T) {
+	rules := []models.TaxRule{
+		rule("DE", "DE", "", "", 1900, true),
+		rule("DE books", "DE", "", "books", 700, true),
+		rule("US-CA", "US", "CA", "", 725, false),
+		rule("US-CA food", "US", "CA", "food", 0, false),
+		//as specific as "DE books", the first one of the two wins
+		rule("DE books again", "de", "", "BOOKS", 500, true),
+		//a category alone is less specific than a country
+		rule("books anywhere", "", "", "books", 300, false),
+	}
+	tests := []struct {
+		country, region, category string
+		want                      string
+	}{
+		{"DE", "", "", "DE"},
+		{"DE", "BY", "toys", "DE"},
+		{"de", "", "books", "DE books"},
+		{"US", "CA", "toys", "US-CA"},
+		{"US", "CA", "food", "US-CA food"},
+		{"US", "NY", "toys", ""},
+		{"US", "NY", "books", "books anywhere"},
+		{"FR", "", "books", "books anywhere"},
+		{"", "", "", ""},
+	}
+	for _, test := range tests {
+		address := &models.Address{Country: str(test.country), Region: str(test.region)}
+		got := ResolveTaxRule(rules, address, str(test.category))
+		name := ""
+		if got != nil {
+			name = *got.Name
+		}
+		if name != test.want {
+			t.Errorf("%s/%s/%s: rule %q, want %q", test.country, test.region, test.category, name, test.want)
+		}
+	}
+	//without an address only the rules that name no place apply
+	if got := ResolveTaxRule(rules, nil, str("books")); got == nil || *got.Name != "books anywhere" {
+		t.Errorf("no address: rule %v, want books anywhere", got)
+	}
+}
+
+func TestCalculateTax(t *testing.T) {
+	address := &models.Address{Country: str("DE")}
+	tests := []struct {
+		name            string
+		rule            models.TaxRule
+		price           int64
+		net, tax, gross int64
+	}{
+		//19% of 1.05 is 0.1995, rounded to 0.20
+		{"exclusive", rule("VAT", "DE", "", "", 1900, false), 105, 105, 20, 125},
+		//5% of 0.10 is exactly half a cent, rounded away from zero
+		{"exclusive half cent", rule("VAT", "DE", "", "", 500, false), 10, 10, 1, 11},
+		//19% of 0.02 is 0.0038, less than half a cent
+		{"exclusive below half cent", rule("VAT", "DE", "", "", 1900, false), 2, 2, 0, 2},
+		//1.19 holds 0.19 of 19% tax
+		{"inclusive", rule("VAT", "DE", "", "", 1900, true), 119, 100, 19, 119},
+		//1.00 / 1.07 = 0.93457, the net is rounded and the tax is the rest
+		{"inclusive rounded", rule("VAT", "DE", "", "", 700, true), 100, 93, 7, 100},
+		//0.21 / 1.05 = 0.2 exactly
+		{"inclusive exact", rule("VAT", "DE", "", "", 500, true), 21, 20, 1, 21},
+		{"zero rate", rule("VAT", "DE", "", "", 0, false), 999, 999, 0, 999},
+		{"other country", rule("VAT", "FR", "", "", 2000, false), 1000, 1000, 0, 1000},
+	}
+	for _, test := range tests {
+		items := []models.ProductUser{{Product_Name: str("pen"), Price: usd(test.price)}}
+		lines, totalTax, totalGross, err := CalculateTax([]models.TaxRule{test.rule}, address, items)
+		if err != nil || len(lines) != 1 {
+			t.Fatalf("%s: %d lines, %v", test.name, len(lines), err)
+		}
+		line := lines[0]
+		if line.Net != usd(test.net) || line.Tax != usd(test.tax) || line.Gross != usd(test.gross) {
+			t.Errorf("%s: net %v tax %v gross %v, want %d %d %d", test.name, line.Net, line.Tax, line.Gross, test.net, test.tax, test.gross)
+		}
+		if totalTax != usd(test.tax) || totalGross != usd(test.gross) {
+			t.Errorf("%s: totals %v %v, want %d %d", test.name, totalTax, totalGross, test.tax, test.gross)
+		}
+	}
+}
+
+func TestCalculateTaxTotals(t *testing.T) {
+	rules := []models.TaxRule{
+		rule("VAT", "DE", "", "", 1900, false),
+		rule("VAT books", "DE", "", "books", 700, false),
+	}
+	items := []models.ProductUser{
+		{Price: usd(105)},
+		{Price: usd(105)},
+		{Price: usd(1000), Category: str("books")},
+	}
+	lines, totalTax, totalGross, err := CalculateTax(rules, &models.Address{Country: str("DE")}, items)
+	//every line is rounded on its own: 0.20 + 0.20 + 0.70, not 19% of 2.10 rounded once
+	if err != nil || totalTax != usd(110) || totalGross != usd(1320) {
+		t.Errorf("totals %v %v, %v, want 1.10 13.20", totalTax, totalGross, err)
+	}
+	if lines[2].Tax_Name != "VAT books" || lines[2].Rate != 700 {
+		t.Errorf("book line taxed with %s at %d", lines[2].Tax_Name, lines[2].Rate)
+	}
+	if lines, totalTax, totalGross, _ := CalculateTax(rules, nil, items); !totalTax.IsZero() || totalGross != usd(1210) || lines[0].Tax_Name != "" {
+		t.Errorf("no address: tax %v gross %v, want no tax", totalTax, totalGross)
+	}
+	mixed := append(items, models.ProductUser{Price: models.NewMoney(100, "EUR")})
+	if _, _, _, err := CalculateTax(rules, &models.Address{Country: str("DE")}, mixed); err != models.ErrCurrencyMismatch {
+		t.Errorf("mixed currencies: error = %v, want %v", err, models.ErrCurrencyMismatch)
+	}
+}
+
+func TestCalculateTaxQuantity(t *testing.T) {
+	address := &models.Address{Country: str("DE")}
+	tests := []struct {
+		name            string
+		rule            models.TaxRule
+		price           int64
+		quantity        int
+		net, tax, gross int64
+	}{
+		//19% of 0.20 is 0.038, 0.04. every piece on its own would be 0.0038, nothing
+		{"whole line exclusive", rule("VAT", "DE", "", "", 1900, false), 2, 10, 20, 4, 24},
+		//3.00 / 1.07 = 2.8037, every piece on its own would give 3 * 0.93
+		{"whole line inclusive", rule("VAT", "DE", "", "", 700, true), 100, 3, 280, 20, 300},
+		//the rate is in basis points, 8.25% of 10.00 is 0.825 and the half cent rounds up
+		{"basis points", rule("VAT", "DE", "", "", 825, false), 500, 2, 1000, 83, 1083},
+		{"one basis point", rule("VAT", "DE", "", "", 1, false), 10000, 1, 10000, 1, 10001},
+		//a line without a quantity is one piece
+		{"no quantity", rule("VAT", "DE", "", "", 1900, false), 105, 0, 105, 20, 125},
+	}
+	for _, test := range tests {
+		items := []models.ProductUser{{Price: usd(test.price), Quantity: test.quantity}}
+		lines, totalTax, totalGross, err := CalculateTax([]models.TaxRule{test.rule}, address, items)
+		if err != nil || len(lines) != 1 {
+			t.Fatalf("%s: %d lines, %v", test.name, len(lines), err)
+		}
+		line := lines[0]
+		if line.Net != usd(test.net) || line.Tax != usd(test.tax) || line.Gross != usd(test.gross) {
+			t.Errorf("%s: net %v tax %v gross %v, want %d %d %d", test.name, line.Net, line.Tax, line.Gross, test.net, test.tax, test.gross)
+		}
+		if totalTax != usd(test.tax) || totalGross != usd(test.gross) || line.Quantity != items[0].Qty() {
+			t.Errorf("%s: totals %v %v quantity %d, want %d %d %d", test.name, totalTax, totalGross, line.Quantity, test.tax, test.gross, items[0].Qty())
+		}
+	}
+}
+
+func usd(amount int64) models.Money {
+	return models.NewMoney(amount, "USD")
+}
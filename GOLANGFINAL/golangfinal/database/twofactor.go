@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two factor authentication is not enabled")
+	ErrNoPendingTwoFactor  = errors.New("start the two factor enrolment first")
+	ErrInvalidCode         = errors.New("the code is invalid")
+	ErrCantUpdateTwoFactor = errors.New("cannot update the two factor authentication")
+)
+
+// StartTOTPEnrolment keeps the new secret as pending until the user confirms it with a code
+func StartTOTPEnrolment(ctx context.Context, userCollection *mongo.Collection, userID string, secret string) error {
+	filter := bson.M{"user_id": userID, "totp_enabled": bson.M{"$ne": true}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_pending_secret": secret}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		return ErrTwoFactorEnabled
+	}
+	return nil
+}
+
+// ConfirmTOTP turns the pending secret on, together with the hashes of the recovery codes
+func ConfirmTOTP(ctx context.Context, userCollection *mongo.Collection, userID string, secret string, step int64, recoveryHashes []string) error {
+	filter := bson.M{"user_id": userID, "totp_enabled": bson.M{"$ne": true}, "totp_pending_secret": secret}
+	update := bson.M{
+		"$set": bson.M{
+			"totp_enabled":   true,
+			"totp_secret":    secret,
+			"totp_last_step": step,
+			"recovery_codes": recoveryHashes,
+		},
+		"$unset": bson.M{"totp_pending_secret": ""},
+	}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoPendingTwoFactor
+	}
+	return nil
+}
+
+// DisableTOTP turns the second factor off and forgets the secret and the recovery codes
+func DisableTOTP(ctx context.Context, userCollection *mongo.Collection, userID string) error {
+	update := bson.M{
+		"$set":   bson.M{"totp_enabled": false, "totp_last_step": 0},
+		"$unset": bson.M{"totp_secret": "", "totp_pending_secret": "", "recovery_codes": ""},
+	}
+	_, err := userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateTwoFactor
+	}
+	return nil
+}
+
+/*
+UseTOTPStep remembers the step of a code that was accepted.
+a code is only accepted for a step after the last one, so a code seen by someone
+else can't be used again while it is still valid
+*/
+func UseTOTPStep(ctx context.Context, userCollection *mongo.Collection, userID string, step int64) error {
+	filter := bson.M{"user_id": userID, "totp_enabled": true, "totp_last_step": bson.M{"$lt": step}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// UseRecoveryCode removes the hash of the recovery code, each code works once
+func UseRecoveryCode(ctx context.Context, userCollection *mongo.Collection, userID string, codeHash string) error {
+	filter := bson.M{"user_id": userID, "totp_enabled": true, "recovery_codes": codeHash}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"recovery_codes": codeHash}})
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateTwoFactor
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// HashRecoveryCode is what is saved of a recovery code, dashes, spaces and case don't matter
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
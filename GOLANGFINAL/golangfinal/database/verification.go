@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidVerificationToken = errors.New("the link is invalid, expired or was already used")
+	ErrCantCreateToken          = errors.New("cannot create the verification token")
+	ErrTooManyEmails            = errors.New("too many emails were sent, try again later")
+	ErrEmailAlreadyVerified     = errors.New("the email is already verified")
+)
+
+// what a verification token is for
+const PurposeVerifyEmail = "verify_email"
+
+// VerificationTokenLifetime is how long the link in the email works, main sets it from the configuration
+var VerificationTokenLifetime = 24 * time.Hour
+
+const (
+	// a new email can be asked for after ResendInterval, and at most MaxEmailsPerDay times a day
+	ResendInterval  = time.Minute
+	MaxEmailsPerDay = 5
+)
+
+/*
+CreateVerificationToken makes a random token for the user and saves its hash.
+the token itself is only returned here, to be put into the link of the email
+*/
+func CreateVerificationToken(ctx context.Context, tokenCollection *mongo.Collection, userID string, purpose string, email string, lifetime time.Duration) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		logError(ctx, err)
+		return "", ErrCantCreateToken
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	now := time.Now()
+	record := models.VerificationToken{
+		Token_ID:   primitive.NewObjectID(),
+		Token_Hash: hashToken(token),
+		User_ID:    userID,
+		Purpose:    purpose,
+		Email:      email,
+		Created_At: now,
+		Expires_At: now.Add(lifetime),
+	}
+	if _, err := tokenCollection.InsertOne(ctx, record); err != nil {
+		logError(ctx, err)
+		return "", ErrCantCreateToken
+	}
+	return token, nil
+}
+
+// usableToken matches the token while it is unused, not expired and for the purpose
+func usableToken(token string, purpose string, now time.Time) bson.M {
+	return bson.M{
+		"token_hash": hashToken(token),
+		"purpose":    purpose,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+}
+
+/*
+FindVerificationToken returns the token without using it, so the request can be checked
+before the token is spent. UseVerificationToken still has to be called after
+*/
+func FindVerificationToken(ctx context.Context, tokenCollection *mongo.Collection, token string, purpose string) (models.VerificationToken, error) {
+	var record models.VerificationToken
+	err := tokenCollection.FindOne(ctx, usableToken(token, purpose, time.Now())).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return record, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		logError(ctx, err)
+		return record, ErrInvalidVerificationToken
+	}
+	return record, nil
+}
+
+/*
+UseVerificationToken marks the token as used and returns it.
+a token works only once, before it expires and for the purpose it was made for
+*/
+func UseVerificationToken(ctx context.Context, tokenCollection *mongo.Collection, token string, purpose string) (models.VerificationToken, error) {
+	var record models.VerificationToken
+	now := time.Now()
+	//finding and marking it in one step, so two clicks can't both use it
+	err := tokenCollection.FindOneAndUpdate(ctx, usableToken(token, purpose, now), bson.M{"$set": bson.M{"used_at": now}}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return record, ErrInvalidVerificationToken
+	}
+	if err != nil {
+		logError(ctx, err)
+		return record, ErrInvalidVerificationToken
+	}
+	return record, nil
+}
+
+// CheckEmailRate tells if another email of the purpose can be sent to the user now
+func CheckEmailRate(ctx context.Context, tokenCollection *mongo.Collection, userID string, purpose string) error {
+	since := time.Now().Add(-24 * time.Hour)
+	filter := bson.M{"user_id": userID, "purpose": purpose, "created_at": bson.M{"$gt": since}}
+	count, err := tokenCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantCreateToken
+	}
+	if count >= MaxEmailsPerDay {
+		return ErrTooManyEmails
+	}
+	var last models.VerificationToken
+	opts := options.FindOne().SetSort(bson.M{"created_at": -1})
+	err = tokenCollection.FindOne(ctx, filter, opts).Decode(&last)
+	if err == nil && time.Since(last.Created_At) < ResendInterval {
+		return ErrTooManyEmails
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		logError(ctx, err)
+		return ErrCantCreateToken
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of the user as verified, only while it is still the address the link was sent to
+func VerifyEmail(ctx context.Context, userCollection *mongo.Collection, userID string, email string) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return ErrUserIDIsNotValid
+	}
+	now := time.Now()
+	filter := bson.M{"_id": id, "email": email}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"email_verified": true, "verified_at": now}})
+	if err != nil {
+		logError(ctx, err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
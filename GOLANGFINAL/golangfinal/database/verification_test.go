@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const tokensNS = "shop.VerificationTokens"
+
+// tokenDocument is the token as the database returns it
+func tokenDocument(mt *mtest.T, token models.VerificationToken) bson.D {
+	mt.Helper()
+	data, err := bson.Marshal(token)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		mt.Fatal(err)
+	}
+	return doc
+}
+
+func TestCreateVerificationToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("saves only the hash", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		token, err := CreateVerificationToken(context.Background(), mt.Coll, "user", PurposeVerifyEmail, "ann@example.com", time.Hour)
+		if err != nil || token == "" {
+			mt.Fatalf("token %q, %v", token, err)
+		}
+		saved := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		sum := sha256.Sum256([]byte(token))
+		if saved.Lookup("token_hash").StringValue() != hex.EncodeToString(sum[:]) || strings.Contains(saved.String(), token) {
+			mt.Errorf("saved token = %s, want only the hash of %q", saved, token)
+		}
+		if saved.Lookup("purpose").StringValue() != PurposeVerifyEmail || saved.Lookup("email").StringValue() != "ann@example.com" {
+			mt.Errorf("saved token = %s", saved)
+		}
+		created, expires := saved.Lookup("created_at").Time(), saved.Lookup("expires_at").Time()
+		if expires.Sub(created) != time.Hour {
+			mt.Errorf("the token lives %s, want %s", expires.Sub(created), time.Hour)
+		}
+	})
+
+	mt.Run("every token is new", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+		first, _ := CreateVerificationToken(context.Background(), mt.Coll, "user", PurposeVerifyEmail, "ann@example.com", time.Hour)
+		second, _ := CreateVerificationToken(context.Background(), mt.Coll, "user", PurposeVerifyEmail, "ann@example.com", time.Hour)
+		if first == second {
+			mt.Errorf("two tokens are both %q", first)
+		}
+	})
+}
+
+func TestUseVerificationToken(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("valid token", func(mt *mtest.T) {
+		record := models.VerificationToken{Token_ID: primitive.NewObjectID(), User_ID: "user", Purpose: PurposeVerifyEmail, Email: "ann@example.com"}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: tokenDocument(mt, record)}))
+		got, err := UseVerificationToken(context.Background(), mt.Coll, "the-token", PurposeVerifyEmail)
+		if err != nil || got.User_ID != "user" || got.Email != "ann@example.com" {
+			mt.Fatalf("token %+v, %v", got, err)
+		}
+		query := mt.GetStartedEvent().Command.Lookup("query").Document()
+		sum := sha256.Sum256([]byte("the-token"))
+		if query.Lookup("token_hash").StringValue() != hex.EncodeToString(sum[:]) || query.Lookup("purpose").StringValue() != PurposeVerifyEmail {
+			mt.Errorf("the token is looked up by %s, want its hash and purpose", query)
+		}
+		//a used token must not match again
+		if used := query.Lookup("used_at"); used.Type != bson.TypeNull {
+			mt.Errorf("used_at = %s, want null", used)
+		}
+	})
+
+	mt.Run("unknown, used or expired token", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "value", Value: nil}))
+		if _, err := UseVerificationToken(context.Background(), mt.Coll, "the-token", PurposeVerifyEmail); err != ErrInvalidVerificationToken {
+			mt.Errorf("error = %v, want %v", err, ErrInvalidVerificationToken)
+		}
+	})
+}
+
+func TestCheckEmailRate(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	sent := func(count int) bson.D {
+		return mtest.CreateCursorResponse(0, tokensNS, mtest.FirstBatch, bson.D{{Key: "n", Value: count}})
+	}
+	last := func(mt *mtest.T, ago time.Duration) bson.D {
+		token := models.VerificationToken{Token_ID: primitive.NewObjectID(), User_ID: "user", Purpose: PurposeVerifyEmail, Created_At: time.Now().Add(-ago)}
+		return mtest.CreateCursorResponse(0, tokensNS, mtest.FirstBatch, tokenDocument(mt, token))
+	}
+
+	tests := []struct {
+		name      string
+		responses func(mt *mtest.T) []bson.D
+		want      error
+	}{
+		{"first email", func(mt *mtest.T) []bson.D {
+			return []bson.D{sent(0), mtest.CreateCursorResponse(0, tokensNS, mtest.FirstBatch)}
+		}, nil},
+		{"last one a while ago", func(mt *mtest.T) []bson.D {
+			return []bson.D{sent(2), last(mt, 2*ResendInterval)}
+		}, nil},
+		{"last one a moment ago", func(mt *mtest.T) []bson.D {
+			return []bson.D{sent(1), last(mt, time.Second)}
+		}, ErrTooManyEmails},
+		{"too many today", func(mt *mtest.T) []bson.D {
+			return []bson.D{sent(MaxEmailsPerDay)}
+		}, ErrTooManyEmails},
+	}
+	for _, test := range tests {
+		mt.Run(test.name, func(mt *mtest.T) {
+			mt.AddMockResponses(test.responses(mt)...)
+			if err := CheckEmailRate(context.Background(), mt.Coll, "user", PurposeVerifyEmail); err != test.want {
+				mt.Errorf("error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	id := primitive.NewObjectID()
+
+	mt.Run("same address", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+		if err := VerifyEmail(context.Background(), mt.Coll, id.Hex(), "ann@example.com"); err != nil {
+			mt.Fatal(err)
+		}
+		//only while the user still has the address the link was sent to
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		if email := update.Lookup("q", "email").StringValue(); email != "ann@example.com" {
+			mt.Errorf("update = %s, want it only for ann@example.com", update)
+		}
+	})
+
+	mt.Run("address changed since", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+		if err := VerifyEmail(context.Background(), mt.Coll, id.Hex(), "old@example.com"); err != ErrInvalidVerificationToken {
+			mt.Errorf("error = %v, want %v", err, ErrInvalidVerificationToken)
+		}
+	})
+
+	mt.Run("invalid user id", func(mt *mtest.T) {
+		if err := VerifyEmail(context.Background(), mt.Coll, "nope", "ann@example.com"); err != ErrUserIDIsNotValid {
+			mt.Errorf("error = %v, want %v", err, ErrUserIDIsNotValid)
+		}
+	})
+}
@@ -0,0 +1,359 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"golangfinal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantFindWishlist   = errors.New("can't find wishlist")
+	ErrCantUpdateWishlist = errors.New("cannot update the wishlist")
+	ErrCantMoveItem       = errors.New("cannot move the item")
+	ErrItemNotInList      = errors.New("the product is not in that list")
+	ErrInvalidList        = errors.New("list must be cart, saved or wishlist")
+)
+
+// the lists a product can be moved between
+const (
+	ListCart     = "cart"
+	ListSaved    = "saved"
+	ListWishlist = "wishlist"
+)
+
+// ItemList points at one of the product lists of the user,
+// Wishlist_ID is only used when List is ListWishlist
+type ItemList struct {
+	List        string
+	Wishlist_ID primitive.ObjectID
+}
+
+func CreateWishlist(ctx context.Context, userCollection *mongo.Collection, userID string, name string) (models.Wishlist, error) {
+	wishlist := models.Wishlist{
+		Wishlist_ID: primitive.NewObjectID(),
+		Name:        &name,
+		Items:       make([]models.ProductUser, 0),
+		Created_At:  time.Now(),
+	}
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return wishlist, ErrUserIDIsNotValid
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "wishlists", Value: wishlist}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return wishlist, ErrCantUpdateWishlist
+	}
+	if result.MatchedCount == 0 {
+		return wishlist, ErrUserIDIsNotValid
+	}
+	return wishlist, nil
+}
+
+func DeleteWishlist(ctx context.Context, userCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	filter := bson.M{"_id": id, "wishlists._id": wishlistID}
+	update := bson.M{"$pull": bson.M{"wishlists": bson.M{"_id": wishlistID}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateWishlist
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlist
+	}
+	return nil
+}
+
+// AddProductToWishlist copies the product into the wishlist, the same way AddProductToCart does
+func AddProductToWishlist(ctx context.Context, prodCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, wishlistID primitive.ObjectID) error {
+	var product models.ProductUser
+	err := prodCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantFindProduct
+	}
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	//the $ points at the wishlist that matched the filter
+	filter := bson.M{"_id": id, "wishlists._id": wishlistID}
+	update := bson.M{"$push": bson.M{"wishlists.$.items": product}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateWishlist
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlist
+	}
+	return nil
+}
+
+func RemoveWishlistItem(ctx context.Context, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, wishlistID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	filter := bson.M{"_id": id, "wishlists._id": wishlistID}
+	update := bson.M{"$pull": bson.M{"wishlists.$.items": bson.M{"_id": productID}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateWishlist
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlist
+	}
+	return nil
+}
+
+/*
+MoveItem takes every copy of the product out of one list and puts it into another one:
+cart -> saved is "save for later", saved -> cart puts it back, and a wishlist can be
+on either side. in the cart the pieces join the line the product already has.
+it is one pipeline update that picks the copies and moves them on the
+server, nothing is read first, so a product added or removed at the same time can't be
+lost or moved twice
+*/
+func MoveItem(ctx context.Context, userCollection *mongo.Collection, productID primitive.ObjectID, userID string, from, to ItemList) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	if !validList(from) || !validList(to) {
+		return ErrInvalidList
+	}
+	if from == to {
+		return nil
+	}
+	//the update only matches while the product is in the source and the target wishlist exists
+	conditions := bson.A{bson.M{"_id": id}, listHolds(from, productID)}
+	if to.List == ListWishlist {
+		conditions = append(conditions, bson.M{"wishlists._id": to.Wishlist_ID})
+	}
+	//the copies are kept in a temporary field between the stages
+	pick := bson.M{"$filter": bson.M{"input": listItemsExpr(from), "as": "item", "cond": bson.M{"$eq": bson.A{"$$item._id", productID}}}}
+	remove := func(items interface{}) bson.M {
+		return bson.M{"$filter": bson.M{"input": bson.M{"$ifNull": bson.A{items, bson.A{}}}, "as": "item", "cond": bson.M{"$ne": bson.A{"$$item._id", productID}}}}
+	}
+	add := func(items interface{}) bson.M {
+		return bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{items, bson.A{}}}, "$moving"}}
+	}
+	lists := bson.M{}
+	for _, list := range []ItemList{from, to} {
+		change := add
+		if list == from {
+			change = remove
+		}
+		switch {
+		//the cart has one line per product like addCartLine makes it, the pieces are added to that line
+		case list.List == ListCart && list == to:
+			lists["usercart"] = mergeIntoCart(productID)
+		case list.List == ListCart:
+			lists["usercart"] = change("$usercart")
+		case list.List == ListSaved:
+			lists["saved_for_later"] = change("$saved_for_later")
+		}
+	}
+	//one or two wishlists change, every other one is kept as it is
+	if from.List == ListWishlist || to.List == ListWishlist {
+		items := interface{}("$$wishlist.items")
+		if to.List == ListWishlist {
+			items = bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$$wishlist._id", to.Wishlist_ID}}, add("$$wishlist.items"), items}}
+		}
+		if from.List == ListWishlist {
+			items = bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$$wishlist._id", from.Wishlist_ID}}, remove("$$wishlist.items"), items}}
+		}
+		lists["wishlists"] = bson.M{"$map": bson.M{"input": "$wishlists", "as": "wishlist", "in": bson.M{"$mergeObjects": bson.A{"$$wishlist", bson.M{"items": items}}}}}
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"moving": pick}}},
+		{{Key: "$set", Value: lists}},
+		{{Key: "$unset", Value: "moving"}},
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"$and": conditions}, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantMoveItem
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+	//nothing matched, the user is read only to tell why
+	var user models.User
+	if err = userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user); err != nil {
+		return ErrUserIDIsNotValid
+	}
+	if _, err = listItems(user, to); err != nil {
+		return err
+	}
+	if _, err = listItems(user, from); err != nil {
+		return err
+	}
+	return ErrItemNotInList
+}
+
+/*
+mergeIntoCart is the cart with the copies in the moving field of MoveItem added to it: the quantity of a
+line of the product that is already there is raised, otherwise one line with all the pieces is added.
+a copy without a quantity is a single piece, like Qty counts it
+*/
+func mergeIntoCart(productID primitive.ObjectID) bson.M {
+	qty := func(item string) bson.M {
+		return bson.M{"$max": bson.A{bson.M{"$ifNull": bson.A{item + ".quantity", 0}}, 1}}
+	}
+	moved := bson.M{"$sum": bson.M{"$map": bson.M{"input": "$moving", "as": "item", "in": qty("$$item")}}}
+	cart := bson.M{"$ifNull": bson.A{"$usercart", bson.A{}}}
+	raised := bson.M{"$map": bson.M{"input": cart, "as": "item", "in": bson.M{"$cond": bson.A{
+		bson.M{"$eq": bson.A{"$$item._id", productID}},
+		bson.M{"$mergeObjects": bson.A{"$$item", bson.M{"quantity": bson.M{"$add": bson.A{qty("$$item"), moved}}}}},
+		"$$item",
+	}}}}
+	line := bson.M{"$mergeObjects": bson.A{bson.M{"$arrayElemAt": bson.A{"$moving", 0}}, bson.M{"quantity": moved, "added_at": "$$NOW"}}}
+	added := bson.M{"$concatArrays": bson.A{cart, bson.A{line}}}
+	return bson.M{"$cond": bson.A{bson.M{"$in": bson.A{productID, bson.M{"$ifNull": bson.A{"$usercart._id", bson.A{}}}}}, raised, added}}
+}
+
+// ShareWishlist gives the wishlist a random token, whoever has the link can read the wishlist
+func ShareWishlist(ctx context.Context, userCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) (string, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return "", ErrUserIDIsNotValid
+	}
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if err != nil {
+		logError(ctx, err)
+		return "", ErrUserIDIsNotValid
+	}
+	//sharing twice gives the same link
+	for _, wishlist := range user.Wishlists {
+		if wishlist.Wishlist_ID == wishlistID && wishlist.Share_Token != nil {
+			return *wishlist.Share_Token, nil
+		}
+	}
+	random := make([]byte, 24)
+	if _, err = rand.Read(random); err != nil {
+		logError(ctx, err)
+		return "", ErrCantUpdateWishlist
+	}
+	token := base64.RawURLEncoding.EncodeToString(random)
+	filter := bson.M{"_id": id, "wishlists._id": wishlistID}
+	update := bson.M{"$set": bson.M{"wishlists.$.share_token": token}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return "", ErrCantUpdateWishlist
+	}
+	if result.MatchedCount == 0 {
+		return "", ErrCantFindWishlist
+	}
+	return token, nil
+}
+
+// UnshareWishlist removes the token, the old link stops working.
+// the field is removed instead of set to null, an unshared wishlist has no token at all
+func UnshareWishlist(ctx context.Context, userCollection *mongo.Collection, userID string, wishlistID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		logError(ctx, err)
+		return ErrUserIDIsNotValid
+	}
+	filter := bson.M{"_id": id, "wishlists._id": wishlistID}
+	update := bson.M{"$unset": bson.M{"wishlists.$.share_token": ""}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logError(ctx, err)
+		return ErrCantUpdateWishlist
+	}
+	if result.MatchedCount == 0 {
+		return ErrCantFindWishlist
+	}
+	return nil
+}
+
+// GetSharedWishlist finds the wishlist behind a share link, nothing else of the owner is returned
+func GetSharedWishlist(ctx context.Context, userCollection *mongo.Collection, token string) (models.Wishlist, error) {
+	var owner models.User
+	//the projection only returns the wishlist that matched the token
+	opts := options.FindOne().SetProjection(bson.M{"wishlists.$": 1})
+	err := userCollection.FindOne(ctx, bson.M{"wishlists.share_token": token}, opts).Decode(&owner)
+	if err != nil || len(owner.Wishlists) == 0 {
+		if err != mongo.ErrNoDocuments {
+			logError(ctx, err)
+		}
+		return models.Wishlist{}, ErrCantFindWishlist
+	}
+	return owner.Wishlists[0], nil
+}
+
+// listItems returns the products of the list and checks that it exists
+func listItems(user models.User, list ItemList) ([]models.ProductUser, error) {
+	switch list.List {
+	case ListCart:
+		return user.UserCart, nil
+	case ListSaved:
+		return user.Saved_For_Later, nil
+	case ListWishlist:
+		for _, wishlist := range user.Wishlists {
+			if wishlist.Wishlist_ID == list.Wishlist_ID {
+				return wishlist.Items, nil
+			}
+		}
+		return nil, ErrCantFindWishlist
+	}
+	return nil, ErrInvalidList
+}
+
+func validList(list ItemList) bool {
+	return list.List == ListCart || list.List == ListSaved || list.List == ListWishlist
+}
+
+// listHolds matches the users that have the product in the list
+func listHolds(list ItemList, productID primitive.ObjectID) bson.M {
+	switch list.List {
+	case ListCart:
+		return bson.M{"usercart._id": productID}
+	case ListSaved:
+		return bson.M{"saved_for_later._id": productID}
+	}
+	return bson.M{"wishlists": bson.M{"$elemMatch": bson.M{"_id": list.Wishlist_ID, "items._id": productID}}}
+}
+
+// listItemsExpr is the aggregation expression of the products in the list
+func listItemsExpr(list ItemList) interface{} {
+	switch list.List {
+	case ListCart:
+		return bson.M{"$ifNull": bson.A{"$usercart", bson.A{}}}
+	case ListSaved:
+		return bson.M{"$ifNull": bson.A{"$saved_for_later", bson.A{}}}
+	}
+	//the items of every wishlist with the id, there is only one
+	return bson.M{"$reduce": bson.M{
+		"input":        bson.M{"$filter": bson.M{"input": "$wishlists", "as": "wishlist", "cond": bson.M{"$eq": bson.A{"$$wishlist._id", list.Wishlist_ID}}}},
+		"initialValue": bson.A{},
+		"in":           bson.M{"$concatArrays": bson.A{"$$value", "$$this.items"}},
+	}}
+}
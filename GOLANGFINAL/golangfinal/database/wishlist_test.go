@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMoveItemIntoCart(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	user, product := primitive.NewObjectID(), primitive.NewObjectID()
+	moved := mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1})
+	//lists is the stage of the pipeline that changes the lists, it takes the update from the events
+	lists := func(mt *mtest.T) bson.Raw {
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		return update.Lookup("u").Array().Index(1).Value().Document().Lookup("$set").Document()
+	}
+
+	mt.Run("into the cart the line of the product is raised", func(mt *mtest.T) {
+		mt.AddMockResponses(moved)
+		if err := MoveItem(context.Background(), mt.Coll, product, user.Hex(), ItemList{List: ListSaved}, ItemList{List: ListCart}); err != nil {
+			mt.Fatal(err)
+		}
+		set := lists(mt)
+		cart := set.Lookup("usercart", "$cond").Array()
+		//the product is looked for in the cart
+		inCart := cart.Index(0).Value().Document().Lookup("$in").Array()
+		if inCart.Index(0).Value().ObjectID() != product || inCart.Index(1).Value().Document().Lookup("$ifNull").Array().Index(0).Value().StringValue() != "$usercart._id" {
+			mt.Fatalf("usercart = %s, want it to check for the product first", cart)
+		}
+		//when it is there its line gets the pieces, otherwise a single line is added
+		raise := cart.Index(1).Value().Document().Lookup("$map", "in", "$cond").Array()
+		if quantity := raise.Index(1).Value().Document().Lookup("$mergeObjects").Array().Index(1).Value().Document().Lookup("quantity", "$add"); quantity.Type != bson.TypeArray {
+			mt.Errorf("usercart = %s, want the quantity of the line raised", cart)
+		}
+		add := cart.Index(2).Value().Document().Lookup("$concatArrays").Array().Index(1).Value().Array()
+		if values, _ := add.Values(); len(values) != 1 {
+			mt.Errorf("usercart = %s, want one line added", cart)
+		}
+		if saved := set.Lookup("saved_for_later", "$filter"); saved.Type != bson.TypeEmbeddedDocument {
+			mt.Errorf("saved_for_later = %s, want the product filtered out", saved)
+		}
+	})
+
+	mt.Run("out of the cart the copies are moved as they are", func(mt *mtest.T) {
+		mt.AddMockResponses(moved)
+		if err := MoveItem(context.Background(), mt.Coll, product, user.Hex(), ItemList{List: ListCart}, ItemList{List: ListSaved}); err != nil {
+			mt.Fatal(err)
+		}
+		set := lists(mt)
+		if saved := set.Lookup("saved_for_later", "$concatArrays"); saved.Type != bson.TypeArray {
+			mt.Errorf("saved_for_later = %s, want the copies added", saved)
+		}
+		if cart := set.Lookup("usercart", "$filter"); cart.Type != bson.TypeEmbeddedDocument {
+			mt.Errorf("usercart = %s, want the product filtered out", cart)
+		}
+	})
+}
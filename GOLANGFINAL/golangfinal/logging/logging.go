@@ -0,0 +1,66 @@
+/*
+Package logging writes the structured logs of the shop with log/slog.
+the fields put in a context with With, like the request id and the user, are added to every
+line logged with that context, also the ones of the database package
+*/
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+type attrsKey struct{}
+
+// With returns a context whose log lines carry the fields, given like the arguments of slog.Info
+func With(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	//a new slice, the parent context keeps its fields
+	attrs = append(attrs[:len(attrs):len(attrs)], slog.Group("", args...).Value.Group()...)
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// contextHandler adds the fields of the context to the records before they are written
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// ParseLevel reads a level like debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// New makes a logger that writes the lines from the level on as json, or as text for reading in a terminal
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	minLevel, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: minLevel}
+	switch format {
+	case "json":
+		return slog.New(contextHandler{slog.NewJSONHandler(w, options)}), nil
+	case "text":
+		return slog.New(contextHandler{slog.NewTextHandler(w, options)}), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
@@ -0,0 +1,188 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the emails of the shop, SMTPMailer in production and Outbox locally and in tests
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var ErrInvalidRecipient = errors.New("invalid email recipient")
+
+// DefaultTimeout is how long SMTPMailer waits for the server when Timeout isn't set
+const DefaultTimeout = 30 * time.Second
+
+// SMTPMailer sends the messages through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	//the longest a message may take, from the dial to the end of the data
+	Timeout time.Duration
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	//a line break in the address would let the caller add headers
+	if msg.To == "" || strings.ContainsAny(msg.To, "\r\n") {
+		return ErrInvalidRecipient
+	}
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.Host, strconv.Itoa(m.Port)))
+	if err != nil {
+		return err
+	}
+	//a server that stops answering runs into the deadline, and a canceled ctx closes the connection,
+	//so nothing is left waiting for the server after Send returned
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	err = m.send(conn, msg)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// send is smtp.SendMail on a connection that is already open
+func (m *SMTPMailer) send(conn net.Conn, msg Message) error {
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		if err = client.Auth(smtp.PlainAuth("", m.Username, m.Password, m.Host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(m.From); err != nil {
+		return err
+	}
+	if err = client.Rcpt(msg.To); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = data.Write(format(m.From, msg)); err != nil {
+		return err
+	}
+	if err = data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+/*
+Outbox keeps the messages instead of sending them.
+the last MaxOutboxMessages can be read with Messages, and when Dir is set
+every message is also written there as a .eml file
+*/
+type Outbox struct {
+	Dir string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// MaxOutboxMessages is how many messages an Outbox keeps in memory, the older ones are forgotten
+const MaxOutboxMessages = 1000
+
+func NewOutbox(dir string) *Outbox {
+	return &Outbox{Dir: dir}
+}
+
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	if msg.To == "" || strings.ContainsAny(msg.To, "\r\n") {
+		return ErrInvalidRecipient
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	//a server that runs with the outbox for long mustn't keep every email in memory
+	if len(o.messages) > MaxOutboxMessages {
+		o.messages = append([]Message(nil), o.messages[len(o.messages)-MaxOutboxMessages:]...)
+	}
+	if o.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(o.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(o.Dir, name), format("outbox@localhost", msg), 0o644)
+}
+
+// Messages returns a copy of everything sent so far
+func (o *Outbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.messages...)
+}
+
+// Last returns the last message sent to the address
+func (o *Outbox) Last(to string) (Message, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if strings.EqualFold(o.messages[i].To, to) {
+			return o.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + strings.NewReplacer("\r", "", "\n", " ").Replace(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
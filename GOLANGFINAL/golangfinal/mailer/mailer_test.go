@@ -0,0 +1,174 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	outbox := NewOutbox(dir)
+	ctx := context.Background()
+	for _, msg := range []Message{
+		{To: "ann@example.com", Subject: "first", Body: "one"},
+		{To: "bob@example.com", Subject: "other", Body: "two"},
+		{To: "ann@example.com", Subject: "second", Body: "three\nlines"},
+	} {
+		if err := outbox.Send(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if messages := outbox.Messages(); len(messages) != 3 {
+		t.Fatalf("the outbox has %d messages, want 3", len(messages))
+	}
+	if last, found := outbox.Last("Ann@Example.com"); !found || last.Subject != "second" {
+		t.Errorf("last message to ann = %+v, %v", last, found)
+	}
+	if _, found := outbox.Last("carl@example.com"); found {
+		t.Error("found a message that was never sent")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 3 {
+		t.Fatalf("%d .eml files, %v", len(files), err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "\r\n\r\n") || !strings.Contains(string(data), "Content-Type: text/plain") {
+		t.Errorf("the file is no email: %q", data)
+	}
+}
+
+func TestInvalidRecipient(t *testing.T) {
+	outbox := NewOutbox("")
+	for _, to := range []string{"", "ann@example.com\r\nBcc: eve@example.com", "ann@example.com\nBcc: eve@example.com"} {
+		if err := outbox.Send(context.Background(), Message{To: to, Subject: "hi"}); err != ErrInvalidRecipient {
+			t.Errorf("send to %q: error = %v, want %v", to, err, ErrInvalidRecipient)
+		}
+	}
+	if messages := outbox.Messages(); len(messages) != 0 {
+		t.Errorf("the outbox has %v", messages)
+	}
+}
+
+func TestFormatSubject(t *testing.T) {
+	//a line break in the subject would start a new header
+	data := string(format("shop@example.com", Message{To: "ann@example.com", Subject: "hi\r\nBcc: eve@example.com", Body: "a\nb"}))
+	if strings.Contains(data, "\r\nBcc:") {
+		t.Errorf("the subject added a header: %q", data)
+	}
+	if !strings.HasSuffix(data, "\r\n\r\na\r\nb") {
+		t.Errorf("body = %q, want the lines ended with CRLF", data)
+	}
+}
+
+func TestOutboxCap(t *testing.T) {
+	outbox := NewOutbox("")
+	for i := 0; i < MaxOutboxMessages+10; i++ {
+		if err := outbox.Send(context.Background(), Message{To: "ann@example.com", Subject: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := outbox.Messages()
+	if len(messages) != MaxOutboxMessages || messages[0].Subject != "10" {
+		t.Errorf("the outbox has %d messages starting with %q, want the last %d", len(messages), messages[0].Subject, MaxOutboxMessages)
+	}
+}
+
+// smtpServer answers the commands of a single connection, or says nothing when silent
+func smtpServer(t *testing.T, silent bool) (*SMTPMailer, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if silent {
+			//holds the connection open until the client gives up
+			_, _ = bufio.NewReader(conn).ReadString(0)
+			return
+		}
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost")
+		var data strings.Builder
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"):
+				reply("250 localhost")
+			case command == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err = reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 ok")
+			case command == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return &SMTPMailer{Host: host, Port: portNumber, From: "shop@example.com", Timeout: time.Second}, received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	m, received := smtpServer(t, false)
+	if err := m.Send(context.Background(), Message{To: "ann@example.com", Subject: "hi", Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if data := <-received; !strings.Contains(data, "To: ann@example.com\r\n") || !strings.HasSuffix(data, "hello\r\n") {
+		t.Errorf("the server got %q", data)
+	}
+}
+
+func TestSMTPMailerTimeout(t *testing.T) {
+	t.Run("server doesn't answer", func(t *testing.T) {
+		m, _ := smtpServer(t, true)
+		m.Timeout = 100 * time.Millisecond
+		start := time.Now()
+		if err := m.Send(context.Background(), Message{To: "ann@example.com"}); err == nil {
+			t.Fatal("a server that never answered took the message")
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Errorf("Send took %s, want it to give up after the timeout", took)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		m, _ := smtpServer(t, true)
+		m.Timeout = time.Minute
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := m.Send(ctx, Message{To: "ann@example.com"}); err != context.DeadlineExceeded {
+			t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
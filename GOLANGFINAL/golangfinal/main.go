@@ -1,28 +1,195 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"golangfinal/config"
 	"golangfinal/controllers"
 	"golangfinal/database"
+	"golangfinal/logging"
+	"golangfinal/metrics"
 	"golangfinal/middleware"
+	"golangfinal/models"
+	"golangfinal/oidclogin"
+	"golangfinal/passwords"
 	"golangfinal/routes"
+	token "golangfinal/tokens"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8000"
+	//the settings come from CONFIG_FILE or config.json and the environment, they are checked before anything starts
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
 	}
-	app := controllers.NewApplication(database.ProductData(database.Client, "Products"), database.UserData(database.Client, "Users"))
+	//every line is written by slog, also the ones of packages that still use the log package
+	logger, err := logging.New(os.Stderr, cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+	//the debug output of gin isn't structured, GIN_MODE=debug still turns it on
+	if os.Getenv(gin.EnvGinMode) == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	config.Current = cfg
+	//the base currency the catalog is priced in
+	models.DefaultCurrency = cfg.Store_Currency
+	//SIGINT and SIGTERM stop the server, also while it is still waiting for the database
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err = run(ctx, cfg, os.Args[1:])
+	stop()
+	if err != nil {
+		slog.Error("stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run connects to the database and runs the command of the arguments, without one it serves until ctx is done
+func run(ctx context.Context, cfg *config.Config, args []string) error {
+	connectCtx, cancel := context.WithTimeout(ctx, cfg.Database.Startup_Timeout.Duration)
+	client, err := database.Connect(connectCtx, cfg.Database)
+	cancel()
+	if err != nil {
+		return err
+	}
+	//closed last, after the server let the running requests finish
+	defer disconnect(client, cfg.Database.Connect_Timeout.Duration)
+	controllers.UseDatabase(client)
+
+	//"go run . migrate" runs the migrations that are missing, creates the indexes and exits
+	if len(args) > 0 && args[0] == "migrate" {
+		ctx, cancel := context.WithTimeout(ctx, cfg.Database.Migration_Timeout.Duration)
+		defer cancel()
+		return database.SetupSchema(ctx, database.ShopData(client))
+	}
+	//"go run . grantscope <email> <scope>" gives a user a scope, like admin
+	if len(args) == 3 && args[0] == "grantscope" {
+		//a typo would be saved and never grant anything
+		if !token.KnownScope(args[2]) {
+			return fmt.Errorf("unknown scope %q, the scopes are %s", args[2], strings.Join(token.Scopes, ", "))
+		}
+		ctx, cancel := context.WithTimeout(ctx, cfg.Server.Request_Timeout.Duration)
+		defer cancel()
+		if err := database.GrantScope(ctx, controllers.UserCollection, args[1], args[2]); err != nil {
+			return err
+		}
+		slog.Info("granted the scope, it applies from the next login", "scope", args[2], "email", args[1])
+		return nil
+	}
+	//the schema is brought up to date on every start, unless MIGRATE_ON_START=false leaves it to "go run . migrate"
+	if cfg.Database.Migrate_On_Start {
+		migrateCtx, cancel := context.WithTimeout(ctx, cfg.Database.Migration_Timeout.Duration)
+		err := database.SetupSchema(migrateCtx, database.ShopData(client))
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	if err := setup(ctx, cfg); err != nil {
+		return err
+	}
+	router, err := newRouter(cfg, client)
+	if err != nil {
+		return err
+	}
+	return serve(ctx, cfg, router)
+}
+
+// setup hands the settings to the packages and loads the keys, the mailer and the login providers
+func setup(ctx context.Context, cfg *config.Config) error {
+	//the keys the tokens are signed with, without any the server doesn't start
+	keys, err := token.LoadKeys(cfg.Tokens.Secret, cfg.Tokens.Keys_Dir, cfg.Tokens.Signing_Kid)
+	if err != nil {
+		return err
+	}
+	token.Keys = keys
+	//how passwords are hashed and which ones are accepted
+	policy, err := cfg.Passwords.Policy()
+	if err != nil {
+		return fmt.Errorf("password policy: %w", err)
+	}
+	passwords.Current = policy
+	//MAILER=smtp sends real emails, MAILER=outbox keeps them, without either the configuration isn't valid
+	controllers.Mailer = cfg.Mail.NewMailer()
+	controllers.AppBaseURL = cfg.Server.Base_URL
+	controllers.QueryTimeout = cfg.Server.Request_Timeout.Duration
+	controllers.LongQueryTimeout = cfg.Server.Long_Request_Timeout.Duration
+	middleware.QueryTimeout = cfg.Server.Request_Timeout.Duration
+	controllers.ReadinessTimeout = cfg.Server.Readiness_Timeout.Duration
+	//the lifetimes of the tokens, the emailed links and the sessions
+	token.AccessTokenLifetime = cfg.Tokens.Access_Lifetime.Duration
+	token.RefreshTokenLifetime = cfg.Tokens.Refresh_Lifetime.Duration
+	token.GuestCartLifetime = cfg.Tokens.Guest_Cart_Lifetime.Duration
+	database.GuestCartLifetime = cfg.Tokens.Guest_Cart_Lifetime.Duration
+	database.SessionLifetime = cfg.Tokens.Refresh_Lifetime.Duration
+	database.VerificationTokenLifetime = cfg.Tokens.Verification_Lifetime.Duration
+	database.ResetTokenLifetime = cfg.Tokens.Reset_Lifetime.Duration
+	//the providers users can sign in with, like OIDC_PROVIDERS=google
+	providerCtx, providerCancel := context.WithTimeout(ctx, cfg.Server.Long_Request_Timeout.Duration)
+	providers, err := oidclogin.LoadProviders(providerCtx, controllers.AppBaseURL, cfg.Providers())
+	providerCancel()
+	if err != nil {
+		return err
+	}
+	controllers.OIDCProviders = providers
+	return nil
+}
+
+// newRouter puts together the routes of the shop
+func newRouter(cfg *config.Config, client *mongo.Client) (*gin.Engine, error) {
+	app := controllers.NewApplication(database.Collection(client, "Products"), database.Collection(client, "Users"), database.Collection(client, "TaxRules"), database.Collection(client, "ExchangeRates"))
 
 	router := gin.New()
-	router.Use(gin.Logger())
+	//the failed logins are counted by the address of the client, without trusted proxies it is the one of
+	//the connection, or anyone could pick a new address for every guess with X-Forwarded-For
+	if err := router.SetTrustedProxies(cfg.Server.Trusted_Proxies); err != nil {
+		return nil, fmt.Errorf("server.trusted_proxies: %w", err)
+	}
+	//first, so every answer and every log line has the id of its request
+	router.Use(middleware.RequestID())
+	router.Use(metrics.HTTP())
+	//the probes of the orchestrator and the scraper come before the logger, they would fill the log
+	router.GET("/healthz", controllers.Healthz())
+	router.GET("/readyz", controllers.Readyz(database.ShopData(client)))
+	router.GET("/version", controllers.Version())
+	if cfg.Server.Metrics_Token == "" {
+		slog.Info("no METRICS_TOKEN, /metrics is off")
+	}
+	router.GET("/metrics", metrics.Handler(cfg.Server.Metrics_Token))
+	router.Use(middleware.RequestLogger())
+	//before anything else, so the preflight requests are answered without a login
+	router.Use(middleware.CORS(cfg.CORS))
 	routes.UserRoutes(router)
-	router.Use(middleware.Authentication())
+	authentication := middleware.Authentication(database.Collection(client, "Sessions"), database.Collection(client, "APIKeys"))
+	routes.AdminRoutes(router.Group("/admin", authentication))
+	//the rest acts for the logged in user, api keys can't use it
+	router.Use(authentication, middleware.RequireUser())
+	router.GET("/users/sessions", controllers.ListSessions())
+	router.DELETE("/users/sessions", controllers.RevokeSession())
+	router.POST("/users/logout", controllers.Logout())
+	router.POST("/users/changepassword", controllers.ChangePassword())
+	router.GET("/users/profile", controllers.GetProfile())
+	router.PATCH("/users/profile", controllers.UpdateProfile())
+	router.POST("/users/changeemail", controllers.ChangeEmail())
+	router.GET("/users/export", controllers.ExportData())
+	router.POST("/users/deleteaccount", controllers.DeleteAccount())
+	router.POST("/users/2fa/enroll", controllers.EnrollTwoFactor())
+	router.POST("/users/2fa/confirm", controllers.ConfirmTwoFactor())
+	router.POST("/users/2fa/disable", controllers.DisableTwoFactor())
 	router.GET("/addtocart", app.AddToCart())
 	router.GET("/removeitem", app.RemoveItem())
 	router.GET("/listcart", controllers.GetItemFromCart())
@@ -31,7 +198,62 @@ func main() {
 	router.PUT("/edithomeaddress", controllers.EditHomeAddress())
 	router.PUT("/editworkaddress", controllers.EditWorkAddress())
 	router.GET("/deleteaddresses", controllers.DeleteAddress())
-	router.GET("/cartcheckout", app.BuyFromCart())
-	router.GET("/instantbuy", app.InstantBuy())
-	log.Fatal(router.Run(":" + port))
+	//placing an order changes data, so it is a POST and can be retried with an Idempotency-Key
+	idempotency := middleware.Idempotency(database.Collection(client, "IdempotencyKeys"))
+	router.POST("/cartcheckout", idempotency, app.BuyFromCart())
+	router.POST("/instantbuy", idempotency, app.InstantBuy())
+	router.POST("/wishlists", app.CreateWishlist())
+	router.GET("/wishlists", app.ListWishlists())
+	router.DELETE("/wishlists", app.DeleteWishlist())
+	router.POST("/addtowishlist", app.AddToWishlist())
+	router.POST("/removefromwishlist", app.RemoveFromWishlist())
+	router.POST("/moveitem", app.MoveItem())
+	router.POST("/wishlists/share", app.ShareWishlist())
+	router.POST("/wishlists/unshare", app.UnshareWishlist())
+	return router, nil
+}
+
+/*
+serve answers requests until ctx is done. then /readyz turns unready for the shutdown delay,
+so the load balancer stops sending requests, the server stops taking new connections
+and waits up to the shutdown timeout for the requests that are running
+*/
+func serve(ctx context.Context, cfg *config.Config, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.Request_Timeout.Duration,
+	}
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+	slog.Info("listening", "port", cfg.Server.Port)
+	select {
+	case err := <-failed:
+		return err
+	case <-ctx.Done():
+	}
+	controllers.ShuttingDown()
+	if delay := cfg.Server.Shutdown_Delay.Duration; delay > 0 {
+		slog.Info("shutting down, not ready anymore", "stopping_in", delay.String())
+		time.Sleep(delay)
+	}
+	slog.Info("shutting down, waiting for the running requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown_Timeout.Duration)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	slog.Info("all requests finished")
+	return nil
+}
+
+// disconnect closes the connections to the database
+func disconnect(client *mongo.Client, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.Disconnect(ctx); err != nil {
+		slog.Error("cannot disconnect from mongodb", "error", err)
+	}
 }
@@ -0,0 +1,185 @@
+/*
+Package metrics has the Prometheus metrics of the shop: the HTTP requests, the operations on
+mongodb and the business events like sign ups and orders. they are served by Handler on /metrics
+*/
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golangfinal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "How long the HTTP requests took, by route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "The HTTP requests that are being answered.",
+	})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongodb_operation_duration_seconds",
+		Help:    "How long the commands sent to mongodb took, by collection and operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"collection", "operation"})
+	mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_operation_errors_total",
+		Help: "The commands sent to mongodb that failed or had write errors, by collection and operation.",
+	}, []string{"collection", "operation"})
+
+	signUps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_signups_total",
+		Help: "The new accounts, by how they signed up: password or oidc.",
+	}, []string{"method"})
+	logins = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shop_logins_total",
+		Help: "The logins that gave out tokens.",
+	})
+	failedLogins = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shop_failed_logins_total",
+		Help: "The logins that were refused: unknown email, wrong password or code, locked or refused by the provider.",
+	})
+	cartAdditions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_cart_additions_total",
+		Help: "The products put in a cart, by the cart: user or guest.",
+	}, []string{"cart"})
+	orders = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_orders_total",
+		Help: "The orders placed, by how: cart or instant.",
+	}, []string{"kind"})
+	revenue = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_revenue_total",
+		Help: "The total price of the orders placed, with tax, in the major unit of the currency.",
+	}, []string{"currency"})
+)
+
+/*
+Handler serves the metrics, only to "Authorization: Bearer <token>", the revenue isn't for everyone.
+without a token nobody can read them, the route answers 404 like it isn't there
+*/
+func Handler(token string) gin.HandlerFunc {
+	handler := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// HTTP measures every request, by the route it matched so the ids in the paths don't make new series
+func HTTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer func() {
+			httpInFlight.Dec()
+			route := c.FullPath()
+			if route == "" {
+				route = "unmatched"
+			}
+			httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+		}()
+		c.Next()
+	}
+}
+
+/*
+CommandMonitor measures the commands the mongodb client sends, it is set on the client options.
+the collection is only named in the started event, it is kept until the command finishes
+*/
+func CommandMonitor() *event.CommandMonitor {
+	var collections sync.Map
+	finished := func(e event.CommandFinishedEvent, failed bool) {
+		collection := ""
+		if name, ok := collections.LoadAndDelete(e.RequestID); ok {
+			collection = name.(string)
+		}
+		mongoDuration.WithLabelValues(collection, e.CommandName).Observe(time.Duration(e.DurationNanos).Seconds())
+		if failed {
+			mongoErrors.WithLabelValues(collection, e.CommandName).Inc()
+		}
+	}
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, e *event.CommandStartedEvent) {
+			collections.Store(e.RequestID, commandCollection(e))
+		},
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			//a duplicate key and the like come back as a successful command with write errors
+			finished(e.CommandFinishedEvent, hasField(e.Reply, "writeErrors") || hasField(e.Reply, "writeConcernError"))
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			finished(e.CommandFinishedEvent, true)
+		},
+	}
+}
+
+// commandCollection finds the collection of a command, like "Users" in {find: "Users", ...}
+func commandCollection(e *event.CommandStartedEvent) string {
+	//getMore names the cursor first and the collection after
+	if e.CommandName == "getMore" {
+		name, _ := e.Command.Lookup("collection").StringValueOK()
+		return name
+	}
+	first, err := e.Command.IndexErr(0)
+	if err != nil {
+		return ""
+	}
+	if name, ok := first.Value().StringValueOK(); ok {
+		return name
+	}
+	return ""
+}
+
+func hasField(document bson.Raw, key string) bool {
+	_, err := document.LookupErr(key)
+	return err == nil
+}
+
+// SignedUp counts a new account, method is password or oidc
+func SignedUp(method string) {
+	signUps.WithLabelValues(method).Inc()
+}
+
+// LoggedIn counts a login that gave out tokens
+func LoggedIn() {
+	logins.Inc()
+}
+
+// LoginFailed counts a refused login
+func LoginFailed() {
+	failedLogins.Inc()
+}
+
+// AddedToCart counts a product put in a cart, cart is user or guest
+func AddedToCart(cart string) {
+	cartAdditions.WithLabelValues(cart).Inc()
+}
+
+// OrderPlaced counts an order and adds its total price to the revenue, kind is cart or instant
+func OrderPlaced(kind string, total models.Money) {
+	orders.WithLabelValues(kind).Inc()
+	revenue.WithLabelValues(total.Currency).Add(float64(total.Amount) / math.Pow10(models.CurrencyExponent(total.Currency)))
+}
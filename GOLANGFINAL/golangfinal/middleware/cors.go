@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golangfinal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+CORS lets the browsers of the allowed origins call the API. a preflight request of an allowed
+origin is answered right here, requests of other origins get no CORS headers and the browser
+keeps their answers from the page. without allowed origins it does nothing
+*/
+func CORS(settings config.CORSConfig) gin.HandlerFunc {
+	anyOrigin := false
+	origins := make(map[string]bool)
+	for _, origin := range settings.Allowed_Origins {
+		if origin == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(origin)] = true
+	}
+	methods := strings.Join(settings.Allowed_Methods, ", ")
+	headers := strings.Join(settings.Allowed_Headers, ", ")
+	exposed := strings.Join(settings.Exposed_Headers, ", ")
+	maxAge := strconv.Itoa(int(settings.Max_Age.Seconds()))
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || len(origins) == 0 {
+			c.Next()
+			return
+		}
+		//the answer depends on the origin, caches must keep them apart
+		c.Writer.Header().Add("Vary", "Origin")
+		if !anyOrigin && !origins[strings.ToLower(origin)] {
+			c.Next()
+			return
+		}
+		if anyOrigin {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+		}
+		if settings.Allow_Credentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if exposed != "" {
+			c.Header("Access-Control-Expose-Headers", exposed)
+		}
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			c.Header("Access-Control-Allow-Methods", methods)
+			c.Header("Access-Control-Allow-Headers", headers)
+			c.Header("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	"golangfinal/database"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// recordingWriter keeps a copy of the response so it can be saved with the key
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+/*
+Idempotency makes a retried request with the same Idempotency-Key header return
+the response of the first one instead of running again, so a double click or a retry
+after a timeout doesn't place two orders.
+the key belongs to the logged in user, so it has to run after Authentication.
+the same key with a different method, path, query or body is answered with 422
+*/
+func Idempotency(idempotencyCollection *mongo.Collection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot read the request body"})
+			return
+		}
+		//the handler still has to be able to read the body
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, c.Request.Method+" "+c.Request.URL.Path+"?"+c.Request.URL.Query().Encode()+"\n")
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+		keyID := c.GetString("uid") + ":" + key
+
+		var ctx, cancel = context.WithTimeout(context.WithoutCancel(c.Request.Context()), QueryTimeout)
+		defer cancel()
+		saved, err := database.StartIdempotentRequest(ctx, idempotencyCollection, keyID, fingerprint)
+		switch err {
+		case nil:
+		case database.ErrIdempotencyKeyReused:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case database.ErrIdempotencyKeyInProgress:
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if saved != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(saved.Status, saved.Content_Type, saved.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		var saveCtx, saveCancel = context.WithTimeout(context.WithoutCancel(c.Request.Context()), QueryTimeout)
+		defer saveCancel()
+		//a failure on our side isn't remembered, the same key can try again
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			_ = database.ForgetIdempotentRequest(saveCtx, idempotencyCollection, keyID)
+			return
+		}
+		_ = database.CompleteIdempotentRequest(saveCtx, idempotencyCollection, keyID, c.Writer.Status(), c.Writer.Header().Get("Content-Type"), writer.body.Bytes())
+	}
+}
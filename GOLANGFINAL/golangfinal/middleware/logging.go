@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"golangfinal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the id of a request from the caller and back in the answer
+const RequestIDHeader = "X-Request-ID"
+
+/*
+RequestID gives every request an id, it is put in the X-Request-ID header of the answer and on
+every log line of the request. an id sent by the caller, like a proxy in front, is kept so the
+request can be followed across services
+*/
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "request_id", id))
+		c.Next()
+	}
+}
+
+// an id from outside ends up in the logs, so it is kept short and plain
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+RequestLogger writes one line per request when it is done, errors of the server as errors and
+errors of the client as warnings. only the path is logged, the query can hold tokens.
+a handler that panics is logged with its stack and answered with 500 instead of dropping the connection
+*/
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				//the client went away, there is nothing to answer
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				slog.ErrorContext(c.Request.Context(), "panic", "panic", recovered, "stack", string(debug.Stack()))
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			}
+			status := c.Writer.Status()
+			level := slog.LevelInfo
+			if status >= 500 {
+				level = slog.LevelError
+			} else if status >= 400 {
+				level = slog.LevelWarn
+			}
+			args := []any{
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"route", c.FullPath(),
+				"status", status,
+				"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+				"size", c.Writer.Size(),
+				"client_ip", c.ClientIP(),
+				"user_agent", c.Request.UserAgent(),
+			}
+			//the errors the handlers attached with c.Error
+			if errs := c.Errors.ByType(gin.ErrorTypePrivate); len(errs) > 0 {
+				args = append(args, "errors", errs.String())
+			}
+			//the context of the request, it carries the user once the authentication knows it
+			slog.Log(c.Request.Context(), level, "request", args...)
+		}()
+		c.Next()
+	}
+}
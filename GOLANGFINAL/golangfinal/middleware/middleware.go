@@ -1,29 +1,130 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"golangfinal/database"
+	"golangfinal/logging"
 	token "golangfinal/tokens"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func Authentication() gin.HandlerFunc {
+// QueryTimeout is how long the middlewares wait for the database, main sets it from the configuration
+var QueryTimeout = 10 * time.Second
+
+// Realm is named in the WWW-Authenticate header of the answers that need a login
+const Realm = "ecommerce"
+
+var (
+	ErrNoToken           = errors.New("no bearer token provided")
+	ErrInsufficientScope = errors.New("the token does not have the scope for this request")
+	ErrUserRequired      = errors.New("this route needs a user login, not an api key")
+)
+
+/*
+Authentication lets a request through with the token of an active session or with an api key.
+the credential is read from "Authorization: Bearer <token or key>", an api key can also come in
+the X-API-Key header and the old "token" header still works.
+a missing or bad credential is answered with 401 and a WWW-Authenticate header like in RFC 6750
+*/
+func Authentication(sessionCollection *mongo.Collection, apiKeyCollection *mongo.Collection) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ClientToken := c.Request.Header.Get("token")
+		ClientToken := bearerToken(c)
 		if ClientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization Header Provided"})
-			c.Abort()
+			unauthorized(c, ErrNoToken)
+			return
+		}
+		//the request context carries the log fields, the lookups still finish when the client goes away
+		var ctx, cancel = context.WithTimeout(context.WithoutCancel(c.Request.Context()), QueryTimeout)
+		defer cancel()
+		//a service calls with an api key, it has scopes but no user
+		if database.IsAPIKey(ClientToken) {
+			apiKey, err := database.UseAPIKey(ctx, apiKeyCollection, ClientToken, c.ClientIP())
+			if err == database.ErrInvalidAPIKey {
+				unauthorized(c, err)
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.Set("api_key", apiKey.Key_ID.Hex())
+			c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "api_key", apiKey.Key_ID.Hex()))
+			c.Set("scopes", apiKey.Scopes)
+			c.Next()
 			return
 		}
 		claims, err := token.ValidateToken(ClientToken)
-		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
+		if err != nil {
+			unauthorized(c, err)
+			return
+		}
+		//a token stops working when its session is logged out
+		err = database.TouchSession(ctx, sessionCollection, claims.Session_ID, claims.Uid, c.ClientIP())
+		if err == database.ErrSessionRevoked {
+			unauthorized(c, err)
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
+		//from here on the log lines of the request name the user
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", claims.Uid))
+		c.Set("sid", claims.Session_ID)
+		c.Set("scopes", strings.Fields(claims.Scope))
 		c.Next()
 	}
 }
+
+// RequireScope lets only credentials with the scope through, it runs after Authentication
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !token.ScopeGranted(c.GetStringSlice("scopes"), scope) {
+			c.Header("WWW-Authenticate", `Bearer realm="`+Realm+`", error="insufficient_scope", scope="`+scope+`"`)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrInsufficientScope.Error(), "scope": scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUser keeps api keys out of the routes that act for the logged in user
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("uid") == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": ErrUserRequired.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if scheme, value, found := strings.Cut(header, " "); found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(value)
+	}
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	return c.GetHeader("token")
+}
+
+func unauthorized(c *gin.Context, err error) {
+	challenge := `Bearer realm="` + Realm + `"`
+	//a request without a token gets no error code, RFC 6750 section 3.1
+	if err != ErrNoToken {
+		challenge += `, error="invalid_token", error_description="` + strings.ReplaceAll(err.Error(), `"`, `'`) + `"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+}
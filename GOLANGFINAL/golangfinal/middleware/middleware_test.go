@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golangfinal/models"
+	token "golangfinal/tokens"
+
+	"github.com/gin-gonic/gin"
+	jwt "github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const (
+	sessionsNS = "shop.Sessions"
+	apiKeysNS  = "shop.APIKeys"
+	testSecret = "a secret only for the tests"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	m.Run()
+}
+
+func useKeys(t *testing.T) {
+	t.Helper()
+	keys, err := token.LoadKeys(testSecret, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := token.Keys
+	token.Keys = keys
+	t.Cleanup(func() { token.Keys = saved })
+}
+
+// jwtFor signs the claims like the keys of useKeys do, expiring in the given time
+func jwtFor(claims token.SignedDetails, expiresIn time.Duration) (string, error) {
+	claims.ExpiresAt = time.Now().Add(expiresIn).Unix()
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString([]byte(testSecret))
+}
+
+// found is the answer to a FindOne that finds v, or nothing when v is nil
+func found(mt *mtest.T, namespace string, v interface{}) bson.D {
+	mt.Helper()
+	if v == nil {
+		return mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch)
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		mt.Fatal(err)
+	}
+	var doc bson.D
+	if err = bson.Unmarshal(data, &doc); err != nil {
+		mt.Fatal(err)
+	}
+	return mtest.CreateCursorResponse(0, namespace, mtest.FirstBatch, doc)
+}
+
+func protectedRouter(mt *mtest.T) *gin.Engine {
+	router := gin.New()
+	router.Use(Authentication(mt.Coll, mt.Coll))
+	router.GET("/users/me", RequireUser(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, c.GetString("uid"))
+	})
+	router.GET("/admin/addproduct", RequireScope(token.ScopeAdmin), func(c *gin.Context) {
+		c.JSON(http.StatusOK, "added")
+	})
+	return router
+}
+
+func TestAuthentication(t *testing.T) {
+	useKeys(t)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+	sessionID := primitive.NewObjectID()
+	signed, _, err := token.TokenGenerator("ann@example.com", "Ann", "Smith", "user", sessionID.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, _, err := token.TokenGenerator("ann@example.com", "Ann", "Smith", "user", sessionID.Hex(), []string{token.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := jwtFor(token.SignedDetails{Uid: "user", Session_ID: sessionID.Hex()}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the session the tokens were signed for, seen a moment ago from the address of httptest
+	now := time.Now()
+	session := models.Session{Session_ID: sessionID, User_ID: "user", IP: "192.0.2.1", Last_Seen: now}
+	const apiKey = "sk_0a1b2c3d_secret"
+	adminKey := models.APIKey{Key_ID: primitive.NewObjectID(), Scopes: []string{token.ScopeAdmin}, Last_Used_At: &now, Last_Used_IP: "192.0.2.1"}
+	otherKey := models.APIKey{Key_ID: primitive.NewObjectID(), Scopes: []string{"orders:read"}, Last_Used_At: &now, Last_Used_IP: "192.0.2.1"}
+
+	tests := []struct {
+		name      string
+		path      string
+		header    string
+		value     string
+		namespace string
+		answer    interface{}
+		want      int
+		challenge string
+	}{
+		{"bearer token", "/users/me", "Authorization", "Bearer " + signed, sessionsNS, session, http.StatusOK, ""},
+		{"lower case scheme", "/users/me", "Authorization", "bearer " + signed, sessionsNS, session, http.StatusOK, ""},
+		{"legacy token header", "/users/me", "token", signed, sessionsNS, session, http.StatusOK, ""},
+		//a request without a token gets no error code, RFC 6750 section 3.1
+		{"no token", "/users/me", "", "", "", nil, http.StatusUnauthorized, `Bearer realm="ecommerce"`},
+		{"other scheme", "/users/me", "Authorization", "Basic " + signed, "", nil, http.StatusUnauthorized, `Bearer realm="ecommerce"`},
+		{"garbage", "/users/me", "Authorization", "Bearer not.a.token", "", nil, http.StatusUnauthorized, `Bearer realm="ecommerce", error="invalid_token"`},
+		{"expired", "/users/me", "Authorization", "Bearer " + expired, "", nil, http.StatusUnauthorized, `Bearer realm="ecommerce", error="invalid_token", error_description="the token is expired"`},
+		{"revoked session", "/users/me", "Authorization", "Bearer " + signed, sessionsNS, nil, http.StatusUnauthorized, `Bearer realm="ecommerce", error="invalid_token"`},
+		{"admin scope", "/admin/addproduct", "Authorization", "Bearer " + admin, sessionsNS, session, http.StatusOK, ""},
+		{"missing scope", "/admin/addproduct", "Authorization", "Bearer " + signed, sessionsNS, session, http.StatusForbidden, `Bearer realm="ecommerce", error="insufficient_scope", scope="admin"`},
+		{"no token for a scope", "/admin/addproduct", "", "", "", nil, http.StatusUnauthorized, `Bearer realm="ecommerce"`},
+		{"api key", "/admin/addproduct", "Authorization", "Bearer " + apiKey, apiKeysNS, adminKey, http.StatusOK, ""},
+		{"api key header", "/admin/addproduct", "X-API-Key", apiKey, apiKeysNS, adminKey, http.StatusOK, ""},
+		//revoked, expired and unknown keys are all not found
+		{"revoked api key", "/admin/addproduct", "X-API-Key", apiKey, apiKeysNS, nil, http.StatusUnauthorized, `Bearer realm="ecommerce", error="invalid_token", error_description="the api key is invalid, expired or revoked"`},
+		{"api key without the scope", "/admin/addproduct", "X-API-Key", apiKey, apiKeysNS, otherKey, http.StatusForbidden, `Bearer realm="ecommerce", error="insufficient_scope", scope="admin"`},
+		//an api key has no user to act for
+		{"api key on a user route", "/users/me", "X-API-Key", apiKey, apiKeysNS, adminKey, http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		mt.Run(test.name, func(mt *mtest.T) {
+			if test.namespace != "" {
+				mt.AddMockResponses(found(mt, test.namespace, test.answer))
+			}
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.header != "" {
+				request.Header.Set(test.header, test.value)
+			}
+			response := httptest.NewRecorder()
+			protectedRouter(mt).ServeHTTP(response, request)
+			if response.Code != test.want {
+				mt.Fatalf("%s = %d %s, want %d", test.path, response.Code, response.Body, test.want)
+			}
+			challenge := response.Header().Get("WWW-Authenticate")
+			if test.challenge == "" && challenge != "" || !strings.HasPrefix(challenge, test.challenge) {
+				mt.Errorf("WWW-Authenticate = %q, want %q", challenge, test.challenge)
+			}
+			if test.challenge == `Bearer realm="ecommerce"` && challenge != test.challenge {
+				mt.Errorf("WWW-Authenticate = %q, want no error code", challenge)
+			}
+			if test.namespace == "" && len(mt.GetAllStartedEvents()) != 0 {
+				mt.Errorf("the database was asked about a credential that can't be valid")
+			}
+		})
+	}
+}
+
+func TestRequireScopeWithoutAuthentication(t *testing.T) {
+	//RequireScope on its own lets nothing through
+	router := gin.New()
+	router.GET("/admin/addproduct", RequireScope(token.ScopeAdmin), func(c *gin.Context) {
+		c.JSON(http.StatusOK, "added")
+	})
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/admin/addproduct", nil))
+	if response.Code != http.StatusForbidden {
+		t.Errorf("addproduct = %d, want %d", response.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,169 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrInvalidRate     = errors.New("exchange rate must be a positive decimal number")
+	ErrInvalidRounding = errors.New("rounding mode must be half_up, half_even, up or down and the increment positive")
+)
+
+// rounding modes used when a converted amount falls between two minor units
+const (
+	RoundHalfUp   = "half_up"
+	RoundHalfEven = "half_even"
+	RoundUp       = "up"
+	RoundDown     = "down"
+)
+
+// RoundingRule says how converted amounts are rounded,
+// Increment is in minor units: 5 rounds CHF to 0.05, 100 rounds to whole units
+type RoundingRule struct {
+	Mode      string `json:"mode"      bson:"mode"`
+	Increment int64  `json:"increment" bson:"increment"`
+}
+
+/*
+ExchangeRate is maintained by the admin, there is no live feed.
+Rate is how many units of Currency one unit of the base currency buys,
+it is a decimal string ("0.0021") so it is stored without float errors
+*/
+type ExchangeRate struct {
+	Currency   string       `json:"currency"   bson:"_id"`
+	Rate       string       `json:"rate"       bson:"rate"`
+	Rounding   RoundingRule `json:"rounding"   bson:"rounding"`
+	Updated_At time.Time    `json:"updated_at" bson:"updated_at"`
+}
+
+// AppliedRate is the conversion recorded on an order
+type AppliedRate struct {
+	Base     string       `json:"base"     bson:"base"`
+	Currency string       `json:"currency" bson:"currency"`
+	Rate     string       `json:"rate"     bson:"rate"`
+	Rounding RoundingRule `json:"rounding" bson:"rounding"`
+	Total    Money        `json:"total"    bson:"total"`
+}
+
+// BaseRate is the rate of the base currency to itself
+func BaseRate() ExchangeRate {
+	return ExchangeRate{Currency: DefaultCurrency, Rate: "1", Rounding: RoundingRule{Mode: RoundHalfUp, Increment: 1}}
+}
+
+func (r ExchangeRate) Validate() error {
+	if !ValidCurrency(r.Currency) {
+		return ErrInvalidCurrency
+	}
+	if rate, ok := new(big.Rat).SetString(r.Rate); !ok || rate.Sign() <= 0 {
+		return ErrInvalidRate
+	}
+	switch r.Rounding.Mode {
+	case RoundHalfUp, RoundHalfEven, RoundUp, RoundDown:
+	default:
+		return ErrInvalidRounding
+	}
+	if r.Rounding.Increment <= 0 {
+		return ErrInvalidRounding
+	}
+	return nil
+}
+
+// Convert turns an amount in the base currency into the currency of the rate
+func (r ExchangeRate) Convert(m Money) (Money, error) {
+	if m.Currency != DefaultCurrency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	return r.convert(m)
+}
+
+func (r ExchangeRate) convert(m Money) (Money, error) {
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return Money{}, ErrInvalidRate
+	}
+	//the minor units of both currencies can have a different number of digits
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	shift := CurrencyExponent(r.Currency) - CurrencyExponent(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		value.Mul(value, scale)
+	} else {
+		value.Quo(value, scale)
+	}
+	amount, err := r.Rounding.round(value)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: amount, Currency: r.Currency}, nil
+}
+
+// ToBase turns an amount in the currency of the rate back into the base currency,
+// it always rounds half up to the minor unit of the base currency
+func (r ExchangeRate) ToBase(m Money) (Money, error) {
+	if m.Currency != r.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return Money{}, ErrInvalidRate
+	}
+	inverse := ExchangeRate{
+		Currency: DefaultCurrency,
+		Rate:     new(big.Rat).Inv(rate).RatString(),
+		Rounding: RoundingRule{Mode: RoundHalfUp, Increment: 1},
+	}
+	return inverse.convert(m)
+}
+
+// Applied records the rate together with the converted total of an order
+func (r ExchangeRate) Applied(total Money) (*AppliedRate, error) {
+	converted, err := r.Convert(total)
+	if err != nil {
+		return nil, err
+	}
+	return &AppliedRate{Base: DefaultCurrency, Currency: r.Currency, Rate: r.Rate, Rounding: r.Rounding, Total: converted}, nil
+}
+
+func (rule RoundingRule) round(value *big.Rat) (int64, error) {
+	increment := rule.Increment
+	if increment <= 0 {
+		increment = 1
+	}
+	//count how many increments fit into the value, then round that count
+	steps := new(big.Rat).Quo(value, new(big.Rat).SetInt64(increment))
+	quotient, remainder := new(big.Int).QuoRem(steps.Num(), steps.Denom(), new(big.Int))
+	if remainder.Sign() != 0 {
+		//twice the remainder compared with the denominator tells if we are past the half
+		half := new(big.Int).Abs(remainder)
+		half.Mul(half, big.NewInt(2))
+		cmp := half.Cmp(steps.Denom())
+		away := false
+		switch rule.Mode {
+		case RoundUp:
+			away = true
+		case RoundDown:
+			away = false
+		case RoundHalfEven:
+			away = cmp > 0 || (cmp == 0 && quotient.Bit(0) == 1)
+		default:
+			away = cmp >= 0
+		}
+		if away {
+			quotient.Add(quotient, big.NewInt(int64(remainder.Sign())))
+		}
+	}
+	quotient.Mul(quotient, big.NewInt(increment))
+	if !quotient.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+	return quotient.Int64(), nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
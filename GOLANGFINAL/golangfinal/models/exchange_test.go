@@ -0,0 +1,99 @@
+package models
+
+import "testing"
+
+func TestConvertRounding(t *testing.T) {
+	tests := []struct {
+		amount    int64
+		rate      string
+		mode      string
+		increment int64
+		want      int64
+	}{
+		//0.5 minor units: half up goes away from zero, half even to the even neighbour
+		{1, "0.5", RoundHalfUp, 1, 1},
+		{1, "0.5", RoundHalfEven, 1, 0},
+		{1, "0.5", RoundUp, 1, 1},
+		{1, "0.5", RoundDown, 1, 0},
+		{3, "0.5", RoundHalfUp, 1, 2},
+		{3, "0.5", RoundHalfEven, 1, 2},
+		{5, "0.5", RoundHalfUp, 1, 3},
+		{5, "0.5", RoundHalfEven, 1, 2},
+		{5, "0.5", RoundDown, 1, 2},
+		//not a half
+		{100, "0.333", RoundHalfUp, 1, 33},
+		{100, "0.333", RoundHalfEven, 1, 33},
+		{100, "0.333", RoundUp, 1, 34},
+		{100, "0.337", RoundHalfUp, 1, 34},
+		{100, "0.337", RoundDown, 1, 33},
+		//refunds round the same way on the other side of zero
+		{-1, "0.5", RoundHalfUp, 1, -1},
+		{-1, "0.5", RoundDown, 1, 0},
+		{-1, "0.5", RoundUp, 1, -1},
+		{-5, "0.5", RoundHalfEven, 1, -2},
+		//an increment of 5 rounds to 0.05
+		{1233, "1", RoundHalfUp, 5, 1235},
+		{1232, "1", RoundHalfUp, 5, 1230},
+		{1232, "1", RoundUp, 5, 1235},
+		{1234, "1", RoundDown, 5, 1230},
+		{1250, "1", RoundHalfEven, 100, 1200},
+		{1350, "1", RoundHalfEven, 100, 1400},
+		//exact conversions aren't touched
+		{1250, "0.9", RoundUp, 1, 1125},
+	}
+	for _, test := range tests {
+		rate := ExchangeRate{Currency: "EUR", Rate: test.rate, Rounding: RoundingRule{Mode: test.mode, Increment: test.increment}}
+		got, err := rate.Convert(NewMoney(test.amount, DefaultCurrency))
+		if err != nil {
+			t.Errorf("%d at %s %s/%d: %v", test.amount, test.rate, test.mode, test.increment, err)
+			continue
+		}
+		if got != NewMoney(test.want, "EUR") {
+			t.Errorf("%d at %s %s/%d = %d, want %d", test.amount, test.rate, test.mode, test.increment, got.Amount, test.want)
+		}
+	}
+}
+
+func TestConvertExponents(t *testing.T) {
+	//12.50 USD at 150 is 1875 JPY, which has no minor units
+	rate := ExchangeRate{Currency: "JPY", Rate: "150", Rounding: RoundingRule{Mode: RoundHalfUp, Increment: 1}}
+	got, err := rate.Convert(NewMoney(1250, "USD"))
+	if err != nil || got != NewMoney(1875, "JPY") {
+		t.Errorf("USD to JPY = %v, %v, want 1875 JPY", got, err)
+	}
+	//10.00 USD at 0.3 is 3.000 KWD, which has three digits
+	rate = ExchangeRate{Currency: "KWD", Rate: "0.3", Rounding: RoundingRule{Mode: RoundHalfUp, Increment: 1}}
+	got, err = rate.Convert(NewMoney(1000, "USD"))
+	if err != nil || got != NewMoney(3000, "KWD") {
+		t.Errorf("USD to KWD = %v, %v, want 3.000 KWD", got, err)
+	}
+	back, err := rate.ToBase(got)
+	if err != nil || back != NewMoney(1000, "USD") {
+		t.Errorf("KWD back to USD = %v, %v, want 10.00 USD", back, err)
+	}
+	if _, err = rate.Convert(NewMoney(1000, "EUR")); err != ErrCurrencyMismatch {
+		t.Errorf("converting EUR with a rate of the base currency: error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+}
+
+func TestExchangeRateValidate(t *testing.T) {
+	valid := RoundingRule{Mode: RoundHalfEven, Increment: 5}
+	tests := []struct {
+		rate ExchangeRate
+		err  error
+	}{
+		{ExchangeRate{Currency: "CHF", Rate: "0.91", Rounding: valid}, nil},
+		{ExchangeRate{Currency: "CHF", Rate: "91/100", Rounding: valid}, nil},
+		{ExchangeRate{Currency: "chf", Rate: "0.91", Rounding: valid}, ErrInvalidCurrency},
+		{ExchangeRate{Currency: "CHF", Rate: "0", Rounding: valid}, ErrInvalidRate},
+		{ExchangeRate{Currency: "CHF", Rate: "-1", Rounding: valid}, ErrInvalidRate},
+		{ExchangeRate{Currency: "CHF", Rate: "abc", Rounding: valid}, ErrInvalidRate},
+		{ExchangeRate{Currency: "CHF", Rate: "0.91", Rounding: RoundingRule{Mode: "nearest", Increment: 1}}, ErrInvalidRounding},
+		{ExchangeRate{Currency: "CHF", Rate: "0.91", Rounding: RoundingRule{Mode: RoundUp, Increment: 0}}, ErrInvalidRounding},
+	}
+	for _, test := range tests {
+		if err := test.rate.Validate(); err != test.err {
+			t.Errorf("Validate(%+v) = %v, want %v", test.rate, err, test.err)
+		}
+	}
+}
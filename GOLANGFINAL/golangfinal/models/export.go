@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Review is a comment of the user on a product, with the product it belongs to
+type Review struct {
+	Product_ID   primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Product_Name *string            `json:"product_name" bson:"product_name"`
+	Comment_ID   primitive.ObjectID `json:"comment_id" bson:"comment_id"`
+	Comment      *string            `json:"comment" bson:"comment"`
+	Rating       *int               `json:"rating" bson:"rating"`
+}
+
+// DataExport is everything the shop holds about a user, it is what the user downloads from /users/export
+type DataExport struct {
+	Exported_At     time.Time     `json:"exported_at"`
+	Profile         Profile       `json:"profile"`
+	Cart            []ProductUser `json:"cart"`
+	Saved_For_Later []ProductUser `json:"saved_for_later"`
+	Wishlists       []Wishlist    `json:"wishlists"`
+	Orders          []Order       `json:"orders"`
+	Reviews         []Review      `json:"reviews"`
+	Sessions        []Session     `json:"sessions"`
+}
@@ -10,7 +10,7 @@ type User struct {
 	ID              primitive.ObjectID `json:"_id" bson:"_id"`
 	First_Name      *string            `json:"first_name" validate:"required,min=2,max=30"`
 	Last_Name       *string            `json:"last_name"  validate:"required,min=2,max=30"`
-	Password        *string            `json:"password"   validate:"required,min=6"`
+	Password        *string            `json:"password"   validate:"required"`
 	Email           *string            `json:"email"      validate:"email,required"`
 	Phone           *string            `json:"phone"      validate:"required"`
 	Token           *string            `json:"token"`
@@ -21,6 +21,25 @@ type User struct {
 	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
 	Address_Details []Address          `json:"address" bson:"address"`
 	Order_Status    []Order            `json:"orders" bson:"orders"`
+	Saved_For_Later []ProductUser      `json:"saved_for_later" bson:"saved_for_later"`
+	Wishlists       []Wishlist         `json:"wishlists" bson:"wishlists"`
+	Email_Verified  bool               `json:"email_verified" bson:"email_verified"`
+	Verified_At     *time.Time         `json:"verified_at" bson:"verified_at"`
+	Token_Version   int                `json:"-" bson:"token_version"`
+	//the scopes the tokens of the user get besides the ones every user has, like "admin"
+	Scopes []string `json:"-" bson:"scopes"`
+	//the new address of a change of email, until the link sent to it is followed
+	Pending_Email *string `json:"-" bson:"pending_email"`
+	//the accounts at login providers the user can sign in with
+	Identities []Identity `json:"identities" bson:"identities"`
+	//two factor authentication, the secrets and the hashes of the recovery codes never leave the server
+	TOTP_Enabled        bool     `json:"totp_enabled" bson:"totp_enabled"`
+	TOTP_Secret         *string  `json:"-" bson:"totp_secret"`
+	TOTP_Pending_Secret *string  `json:"-" bson:"totp_pending_secret"`
+	TOTP_Last_Step      int64    `json:"-" bson:"totp_last_step"`
+	Recovery_Codes      []string `json:"-" bson:"recovery_codes"`
+	//set when the account was deleted, only the orders are kept
+	Deleted_At *time.Time `json:"-" bson:"deleted_at"`
 }
 /*
 (*) in front of a variable type denotes a pointer
@@ -32,7 +51,10 @@ the other variable in the struct are not pointers, and their values must exist f
 type Product struct {
 	Product_ID   		primitive.ObjectID 	`bson:"_id"`
 	Product_Name 		*string            	`json:"product_name"`
-	Price        		*int           		`json:"price"`
+	Price        		*Money         		`json:"price" bson:"price"`
+	Display_Price       *Money         `json:"display_price,omitempty" bson:"-"`
+	Category            *string        `json:"category" bson:"category"`
+	Stock               *int           `json:"stock" bson:"stock"`
 	Total_Rating        *int           `json:"total_rating" bson:"total_rating"`
 	Comment             []Comment           `json:"comment" bson:"comment"`
 }
@@ -40,12 +62,37 @@ type Comment struct{
 	Comment_id 		primitive.ObjectID		`bson:"_id"`
 	Comment    		*string               	`json:"comment" bson:"comment"`
 	Rating          *int                    `json:"rating" bson:"rating"`
+	//who wrote it, removed when the account is deleted so the review stays without an author
+	User_ID         string                  `json:"-" bson:"user_id,omitempty"`
 }
 
 type ProductUser struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name" bson:"product_name"`
-	Price        int                `json:"price"  bson:"price"`
+	Product_ID    primitive.ObjectID `bson:"_id"`
+	Product_Name  *string            `json:"product_name" bson:"product_name"`
+	Price         Money              `json:"price"  bson:"price"`
+	Display_Price *Money             `json:"display_price,omitempty" bson:"-"`
+	Category      *string            `json:"category" bson:"category"`
+	Quantity      int                `json:"quantity" bson:"quantity"`
+	Added_At      time.Time          `json:"added_at" bson:"added_at"`
+}
+
+// Qty is the number of pieces on the line,
+// the lines saved before the quantity existed were always a single piece
+func (p ProductUser) Qty() int {
+	if p.Quantity <= 0 {
+		return 1
+	}
+	return p.Quantity
+}
+
+// GuestCart belongs to a visitor that hasn't logged in yet,
+// it is found by the signed cart token and merged into the user cart on login
+type GuestCart struct {
+	Cart_ID    primitive.ObjectID `json:"_id"        bson:"_id"`
+	Items      []ProductUser      `json:"usercart"   bson:"usercart"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+	Updated_At time.Time          `json:"updated_at" bson:"updated_at"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
 }
 
 type Address struct {
@@ -54,6 +101,8 @@ type Address struct {
 	Street     *string            `json:"street_name" bson:"street_name"`
 	City       *string            `json:"city_name" bson:"city_name"`
 	Pincode    *string            `json:"pin_code" bson:"pin_code"`
+	Region     *string            `json:"region" bson:"region"`
+	Country    *string            `json:"country" bson:"country"`
 }
 
 type Order struct {
@@ -61,8 +110,11 @@ type Order struct {
 	Order_Cart     []ProductUser      `json:"order_list"  bson:"order_list"`
 	//holds the list of products from the ProductCart that are being actually bought!
 	Orderered_At   time.Time          `json:"ordered_on"  bson:"ordered_on"`
-	Price          int                `json:"total_price" bson:"total_price"`
-	Discount       *int               `json:"discount"    bson:"discount"`
+	Price          Money              `json:"total_price" bson:"total_price"`
+	Discount       *Money             `json:"discount"    bson:"discount"`
+	Tax_Lines      []TaxLine          `json:"tax_lines"   bson:"tax_lines"`
+	Tax_Total      Money              `json:"total_tax"   bson:"total_tax"`
+	Exchange_Rate  *AppliedRate       `json:"exchange_rate" bson:"exchange_rate"`
 	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
 }
 
@@ -70,3 +122,110 @@ type Payment struct {
 	Digital bool `json:"digital" bson:"digital"`
 	COD     bool `json:"cod"     bson:"cod"`
 }
+
+// TaxRule is configured by the admin and matched against the shipping address
+// and the category of every product in the cart.
+// empty Country, Region or Category means the rule applies to any value
+// Rate is in basis points: 1200 means 12%
+// Inclusive - the product price already contains the tax,
+// otherwise the tax is added on top of the product price
+type TaxRule struct {
+	TaxRule_ID primitive.ObjectID `bson:"_id"`
+	Name       *string            `json:"name"      bson:"name"      validate:"required"`
+	Country    *string            `json:"country"   bson:"country"`
+	Region     *string            `json:"region"    bson:"region"`
+	Category   *string            `json:"category"  bson:"category"`
+	Rate       int                `json:"rate"      bson:"rate"      validate:"min=0,max=10000"`
+	Inclusive  bool               `json:"inclusive" bson:"inclusive"`
+}
+
+// TaxLine is the tax breakdown of a single cart line
+type TaxLine struct {
+	Product_ID   primitive.ObjectID `json:"product_id"   bson:"product_id"`
+	Product_Name *string            `json:"product_name" bson:"product_name"`
+	Tax_Name     string             `json:"tax_name"     bson:"tax_name"`
+	Quantity     int                `json:"quantity"     bson:"quantity"`
+	Rate         int                `json:"rate"         bson:"rate"`
+	Inclusive    bool               `json:"inclusive"    bson:"inclusive"`
+	Net          Money              `json:"net"          bson:"net"`
+	Tax          Money              `json:"tax"          bson:"tax"`
+	Gross        Money              `json:"gross"        bson:"gross"`
+}
+
+// Wishlist is a named list of products the user wants to buy later,
+// anyone with the Share_Token can see it but not change it
+type Wishlist struct {
+	Wishlist_ID primitive.ObjectID `json:"_id"         bson:"_id"`
+	Name        *string            `json:"name"        bson:"name"        validate:"required,min=1,max=50"`
+	Items       []ProductUser      `json:"items"       bson:"items"`
+	Share_Token *string            `json:"share_token,omitempty" bson:"share_token,omitempty"`
+	Created_At  time.Time          `json:"created_at"  bson:"created_at"`
+}
+
+// VerificationToken is a single use link sent by email, only the hash of the token is saved
+type VerificationToken struct {
+	Token_ID   primitive.ObjectID `bson:"_id"`
+	Token_Hash string             `bson:"token_hash"`
+	User_ID    string             `bson:"user_id"`
+	Purpose    string             `bson:"purpose"`
+	Email      string             `bson:"email"`
+	Created_At time.Time          `bson:"created_at"`
+	Expires_At time.Time          `bson:"expires_at"`
+	Used_At    *time.Time         `bson:"used_at"`
+}
+
+// LoginAttempt counts the failed logins of an account or of an ip address
+type LoginAttempt struct {
+	Attempt_ID   string    `json:"id" bson:"_id"`
+	Failures     int       `json:"failures" bson:"failures"`
+	Last_Failure time.Time `json:"last_failure" bson:"last_failure"`
+	Locked_Until time.Time `json:"locked_until" bson:"locked_until"`
+}
+
+// Identity is an account at an OpenID Connect provider linked to a user
+type Identity struct {
+	Provider  string    `json:"provider" bson:"provider"`
+	Subject   string    `json:"-" bson:"subject"`
+	Email     string    `json:"email" bson:"email"`
+	Linked_At time.Time `json:"linked_at" bson:"linked_at"`
+}
+
+// APIKey lets a service call the API without a user, only the hash of the key is saved
+type APIKey struct {
+	Key_ID       primitive.ObjectID `json:"id" bson:"_id"`
+	Name         *string            `json:"name" bson:"name" validate:"required,min=1,max=100"`
+	Prefix       string             `json:"prefix" bson:"prefix"`
+	Key_Hash     string             `json:"-" bson:"key_hash"`
+	Scopes       []string           `json:"scopes" bson:"scopes" validate:"required,min=1"`
+	Created_By   string             `json:"created_by" bson:"created_by"`
+	Created_At   time.Time          `json:"created_at" bson:"created_at"`
+	Expires_At   *time.Time         `json:"expires_at" bson:"expires_at"`
+	Revoked_At   *time.Time         `json:"revoked_at" bson:"revoked_at"`
+	Last_Used_At *time.Time         `json:"last_used_at" bson:"last_used_at"`
+	Last_Used_IP string             `json:"last_used_ip" bson:"last_used_ip"`
+}
+
+// Session is one login of a user on one device, the tokens of the login name it
+type Session struct {
+	Session_ID primitive.ObjectID `json:"session_id" bson:"_id"`
+	User_ID    string             `json:"-" bson:"user_id"`
+	User_Agent string             `json:"user_agent" bson:"user_agent"`
+	IP         string             `json:"ip" bson:"ip"`
+	Created_At time.Time          `json:"created_at" bson:"created_at"`
+	Last_Seen  time.Time          `json:"last_seen" bson:"last_seen"`
+	Expires_At time.Time          `json:"expires_at" bson:"expires_at"`
+	Revoked_At *time.Time         `json:"-" bson:"revoked_at"`
+	Current    bool               `json:"current" bson:"-"`
+}
+
+// IdempotencyKey remembers a request sent with an Idempotency-Key header and its response,
+// the same key sent again gets the same response instead of running the request twice
+type IdempotencyKey struct {
+	Key_ID       string    `bson:"_id"`
+	Fingerprint  string    `bson:"fingerprint"`
+	Completed    bool      `bson:"completed"`
+	Status       int       `bson:"status"`
+	Content_Type string    `bson:"content_type"`
+	Body         []byte    `bson:"body"`
+	Created_At   time.Time `bson:"created_at"`
+}
@@ -0,0 +1,230 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+/*
+Money is an amount in the minor units of its currency (cents, tiyn...)
+plus the ISO 4217 code of the currency, 12.50 USD is {1250 USD}
+ints are used instead of floats so that 0.1+0.2 is always 0.3
+*/
+type Money struct {
+	Amount   int64  `json:"amount"   bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+// DefaultCurrency is the base currency of the store, the catalog is priced in it.
+// the prices that were saved before they had a currency are read in it too
+var DefaultCurrency = "USD"
+
+var (
+	ErrCurrencyMismatch = errors.New("currencies do not match")
+	ErrMoneyOverflow    = errors.New("money amount is out of range")
+	ErrInvalidCurrency  = errors.New("currency must be a 3 letter ISO 4217 code")
+	ErrInvalidAmount    = errors.New("invalid money amount")
+)
+
+// number of digits after the decimal point, every currency that is not listed uses 2
+var currencyExponents = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+	"XAF": 0, "XOF": 0,
+}
+
+// CurrencyExponent returns the number of minor unit digits of the currency
+func CurrencyExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+func ValidCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, r := range currency {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney reads an amount written in major units ("12.50") in the given currency
+func ParseMoney(amount string, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, ErrInvalidCurrency
+	}
+	exp := CurrencyExponent(currency)
+	amount = strings.TrimSpace(amount)
+	negative := strings.HasPrefix(amount, "-")
+	amount = strings.TrimPrefix(amount, "-")
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if whole == "" || len(fraction) > exp || strings.ContainsAny(whole+fraction, "+-") {
+		return Money{}, ErrInvalidAmount
+	}
+	fraction += strings.Repeat("0", exp-len(fraction))
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		minor = -minor
+	}
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns m+other, both have to be in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m-other, both have to be in the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrMoneyOverflow
+	}
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul multiplies the amount by a quantity
+func (m Money) Mul(quantity int64) (Money, error) {
+	return m.MulRate(quantity, 1)
+}
+
+// MulRate multiplies the amount by num/den and rounds half away from zero
+// it is used for percentages: 12% of m is m.MulRate(12, 100)
+func (m Money) MulRate(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, ErrInvalidAmount
+	}
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num))
+	denominator := big.NewInt(den)
+	if den < 0 {
+		product.Neg(product)
+		denominator.Neg(denominator)
+	}
+	//round half away from zero: (2*a + b) / 2b for positive a
+	negative := product.Sign() < 0
+	product.Abs(product)
+	product.Mul(product, big.NewInt(2)).Add(product, denominator)
+	product.Quo(product, denominator.Mul(denominator, big.NewInt(2)))
+	if negative {
+		product.Neg(product)
+	}
+	if !product.IsInt64() {
+		return Money{}, ErrMoneyOverflow
+	}
+	return Money{Amount: product.Int64(), Currency: m.Currency}, nil
+}
+
+// String formats the money in major units: "12.50 USD"
+func (m Money) String() string {
+	exp := CurrencyExponent(m.Currency)
+	amount := new(big.Int).Abs(big.NewInt(m.Amount)).String()
+	if len(amount) <= exp {
+		amount = strings.Repeat("0", exp-len(amount)+1) + amount
+	}
+	if exp > 0 {
+		amount = amount[:len(amount)-exp] + "." + amount[len(amount)-exp:]
+	}
+	if m.Amount < 0 {
+		amount = "-" + amount
+	}
+	return fmt.Sprintf("%s %s", amount, m.Currency)
+}
+
+// the field names have to stay the same in json and bson
+type money struct {
+	Amount   int64  `json:"amount"   bson:"amount"`
+	Currency string `json:"currency" bson:"currency"`
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var decoded money
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("money must be an object with amount and currency: %w", err)
+	}
+	if !ValidCurrency(decoded.Currency) {
+		return ErrInvalidCurrency
+	}
+	*m = Money(decoded)
+	return nil
+}
+
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(money(m))
+}
+
+// UnmarshalBSONValue also reads the bare numbers prices used to be saved as,
+// they were whole units of DefaultCurrency
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*m = Money{}
+		return nil
+	case bsontype.EmbeddedDocument:
+		var decoded money
+		if err := raw.Unmarshal(&decoded); err != nil {
+			return err
+		}
+		*m = Money(decoded)
+		return nil
+	case bsontype.Int32, bsontype.Int64, bsontype.Double:
+		return m.fromLegacy(raw)
+	}
+	return fmt.Errorf("cannot decode %s into money", t)
+}
+
+func (m *Money) fromLegacy(raw bson.RawValue) error {
+	scale := int64(math.Pow10(CurrencyExponent(DefaultCurrency)))
+	var legacy Money
+	var err error
+	switch raw.Type {
+	case bsontype.Int32:
+		legacy, err = NewMoney(int64(raw.Int32()), DefaultCurrency).Mul(scale)
+	case bsontype.Int64:
+		legacy, err = NewMoney(raw.Int64(), DefaultCurrency).Mul(scale)
+	default:
+		major := raw.Double() * float64(scale)
+		if math.IsNaN(major) || math.Abs(major) >= math.MaxInt64 {
+			return ErrMoneyOverflow
+		}
+		legacy = NewMoney(int64(math.Round(major)), DefaultCurrency)
+	}
+	if err != nil {
+		return err
+	}
+	*m = legacy
+	return nil
+}
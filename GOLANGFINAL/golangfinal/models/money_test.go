@@ -0,0 +1,137 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     Money
+		err      error
+	}{
+		{"12.50", "USD", Money{1250, "USD"}, nil},
+		{"12.5", "USD", Money{1250, "USD"}, nil},
+		{"12", "USD", Money{1200, "USD"}, nil},
+		{" 7 ", "USD", Money{700, "USD"}, nil},
+		{"-3.10", "USD", Money{-310, "USD"}, nil},
+		{"0.01", "USD", Money{1, "USD"}, nil},
+		{"1500", "JPY", Money{1500, "JPY"}, nil},
+		{"0.001", "KWD", Money{1, "KWD"}, nil},
+		{"1.234", "USD", Money{}, ErrInvalidAmount},
+		{"1.5", "JPY", Money{}, ErrInvalidAmount},
+		{"", "USD", Money{}, ErrInvalidAmount},
+		{".50", "USD", Money{}, ErrInvalidAmount},
+		{"+5", "USD", Money{}, ErrInvalidAmount},
+		{"--5", "USD", Money{}, ErrInvalidAmount},
+		{"abc", "USD", Money{}, ErrInvalidAmount},
+		{"99999999999999999999", "USD", Money{}, ErrInvalidAmount},
+		{"5", "usd", Money{}, ErrInvalidCurrency},
+		{"5", "US", Money{}, ErrInvalidCurrency},
+	}
+	for _, test := range tests {
+		got, err := ParseMoney(test.amount, test.currency)
+		if err != test.err || got != test.want {
+			t.Errorf("ParseMoney(%q, %q) = %v, %v, want %v, %v", test.amount, test.currency, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestMulRate(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		num, den int64
+		want     int64
+		err      error
+	}{
+		//12% of 12.50
+		{1250, 12, 100, 150, nil},
+		//halves are rounded away from zero
+		{125, 1, 2, 63, nil},
+		{-125, 1, 2, -63, nil},
+		{124, 1, 2, 62, nil},
+		{100, 1, 3, 33, nil},
+		{200, 1, 3, 67, nil},
+		//a negative denominator flips the sign
+		{100, 1, -3, -33, nil},
+		{100, -1, -3, 33, nil},
+		{0, 7, 9, 0, nil},
+		{100, 1, 0, 0, ErrInvalidAmount},
+		{math.MaxInt64, 2, 1, 0, ErrMoneyOverflow},
+	}
+	for _, test := range tests {
+		got, err := NewMoney(test.amount, "USD").MulRate(test.num, test.den)
+		if err != test.err {
+			t.Errorf("%d.MulRate(%d, %d) error = %v, want %v", test.amount, test.num, test.den, err, test.err)
+			continue
+		}
+		if err == nil && got != NewMoney(test.want, "USD") {
+			t.Errorf("%d.MulRate(%d, %d) = %v, want %d", test.amount, test.num, test.den, got, test.want)
+		}
+	}
+}
+
+func TestMoneyAdd(t *testing.T) {
+	sum, err := NewMoney(1250, "USD").Add(NewMoney(-50, "USD"))
+	if err != nil || sum != NewMoney(1200, "USD") {
+		t.Errorf("Add = %v, %v, want 12.00 USD", sum, err)
+	}
+	if _, err = NewMoney(1, "USD").Add(NewMoney(1, "EUR")); err != ErrCurrencyMismatch {
+		t.Errorf("adding EUR to USD: error = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if _, err = NewMoney(math.MaxInt64, "USD").Add(NewMoney(1, "USD")); err != ErrMoneyOverflow {
+		t.Errorf("adding past MaxInt64: error = %v, want %v", err, ErrMoneyOverflow)
+	}
+	if _, err = NewMoney(0, "USD").Sub(NewMoney(math.MinInt64, "USD")); err != ErrMoneyOverflow {
+		t.Errorf("subtracting MinInt64: error = %v, want %v", err, ErrMoneyOverflow)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{Money{1250, "USD"}, "12.50 USD"},
+		{Money{5, "USD"}, "0.05 USD"},
+		{Money{-1250, "USD"}, "-12.50 USD"},
+		{Money{0, "USD"}, "0.00 USD"},
+		{Money{1500, "JPY"}, "1500 JPY"},
+		{Money{1, "KWD"}, "0.001 KWD"},
+	}
+	for _, test := range tests {
+		if got := test.money.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.money, got, test.want)
+		}
+	}
+}
+
+func TestMoneyBSON(t *testing.T) {
+	type product struct {
+		Price Money `bson:"price"`
+	}
+	data, err := bson.Marshal(product{Price: NewMoney(1250, "EUR")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded product
+	if err = bson.Unmarshal(data, &decoded); err != nil || decoded.Price != NewMoney(1250, "EUR") {
+		t.Errorf("round trip = %v, %v, want 12.50 EUR", decoded.Price, err)
+	}
+	//the prices saved before Money were whole units of DefaultCurrency
+	legacy := []interface{}{int32(12), int64(12), 12.0}
+	for _, price := range legacy {
+		data, err := bson.Marshal(bson.M{"price": price})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded product
+		if err = bson.Unmarshal(data, &decoded); err != nil || decoded.Price != NewMoney(1200, DefaultCurrency) {
+			t.Errorf("legacy %T price = %v, %v, want 12.00 %s", price, decoded.Price, err, DefaultCurrency)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+/*
+Profile is what the API shows of a user. it is built from User field by field,
+so a new secret on User never ends up in an answer by accident
+*/
+type Profile struct {
+	User_ID         string     `json:"user_id"`
+	First_Name      *string    `json:"first_name"`
+	Last_Name       *string    `json:"last_name"`
+	Email           *string    `json:"email"`
+	Email_Verified  bool       `json:"email_verified"`
+	Pending_Email   *string    `json:"pending_email,omitempty"`
+	Phone           *string    `json:"phone"`
+	TOTP_Enabled    bool       `json:"totp_enabled"`
+	Has_Password    bool       `json:"has_password"`
+	Identities      []Identity `json:"identities"`
+	Address_Details []Address  `json:"address"`
+	Created_At      time.Time  `json:"created_at"`
+	Updated_At      time.Time  `json:"updated_at"`
+}
+
+// Profile returns the profile of the user
+func (user User) Profile() Profile {
+	identities := user.Identities
+	if identities == nil {
+		identities = make([]Identity, 0)
+	}
+	addresses := user.Address_Details
+	if addresses == nil {
+		addresses = make([]Address, 0)
+	}
+	return Profile{
+		User_ID:         user.User_ID,
+		First_Name:      user.First_Name,
+		Last_Name:       user.Last_Name,
+		Email:           user.Email,
+		Email_Verified:  user.Email_Verified,
+		Pending_Email:   user.Pending_Email,
+		Phone:           user.Phone,
+		TOTP_Enabled:    user.TOTP_Enabled,
+		Has_Password:    user.Password != nil,
+		Identities:      identities,
+		Address_Details: addresses,
+		Created_At:      user.Created_At,
+		Updated_At:      user.Updated_At,
+	}
+}
@@ -0,0 +1,165 @@
+package oidclogin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown login provider")
+	ErrNoIDToken       = errors.New("the provider did not return an id token")
+	ErrNonceMismatch   = errors.New("the id token was not issued for this login")
+)
+
+// Provider is an OpenID Connect provider users can sign in with
+type Provider struct {
+	Name     string
+	verifier *oidc.IDTokenVerifier
+	config   oauth2.Config
+	client   *http.Client
+}
+
+// Identity is what the provider tells about the user that signed in
+type Identity struct {
+	Provider       string
+	Subject        string
+	Email          string
+	Email_Verified bool
+	First_Name     string
+	Last_Name      string
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ValidName tells if name can be a provider, it is part of the path of the callback
+func ValidName(name string) bool {
+	return providerName.MatchString(name)
+}
+
+// Settings are what the shop is registered with at a provider
+type Settings struct {
+	Issuer        string
+	Client_ID     string
+	Client_Secret string
+	//asked for besides openid, without any email and profile
+	Scopes []string
+}
+
+/*
+LoadProviders sets up the providers of the configuration by their names. the issuer can be any
+OpenID provider, also one on localhost, its endpoints and keys are found through the discovery document.
+the provider redirects back to <baseURL>/users/oidc/<name>/callback
+*/
+func LoadProviders(ctx context.Context, baseURL string, settings map[string]Settings) (map[string]*Provider, error) {
+	providers := make(map[string]*Provider)
+	for name, provider := range settings {
+		if !ValidName(name) {
+			return nil, fmt.Errorf("invalid provider name %q", name)
+		}
+		if provider.Issuer == "" || provider.Client_ID == "" {
+			return nil, fmt.Errorf("provider %s needs an issuer and a client id", name)
+		}
+		scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+		if len(provider.Scopes) > 0 {
+			scopes = append([]string{oidc.ScopeOpenID}, provider.Scopes...)
+		}
+		redirectURL := strings.TrimSuffix(baseURL, "/") + "/users/oidc/" + name + "/callback"
+		loaded, err := NewProvider(ctx, name, provider.Issuer, provider.Client_ID, provider.Client_Secret, redirectURL, scopes, nil)
+		if err != nil {
+			//a provider that is down doesn't keep the shop from starting
+			slog.WarnContext(ctx, "login provider unavailable", "provider", name, "error", err)
+			continue
+		}
+		providers[name] = loaded
+	}
+	return providers, nil
+}
+
+// NewProvider reads the discovery document of the issuer, client is used for every call to the provider and may be nil
+func NewProvider(ctx context.Context, name string, issuer string, clientID string, clientSecret string, redirectURL string, scopes []string, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	ctx = oidc.ClientContext(ctx, client)
+	discovered, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Provider{
+		Name:     name,
+		verifier: discovered.Verifier(&oidc.Config{ClientID: clientID}),
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     discovered.Endpoint(),
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		client: client,
+	}, nil
+}
+
+// AuthURL is where the user is sent to sign in, with the state, the nonce and the PKCE challenge of the verifier
+func (p *Provider) AuthURL(state string, nonce string, verifier string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+/*
+Exchange trades the code of the callback for the tokens and checks the id token:
+its signature, issuer, audience and expiry, and that it carries the nonce of this login
+*/
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Identity, error) {
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return Identity{}, ErrNoIDToken
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, err
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, ErrNonceMismatch
+	}
+	var claims struct {
+		Email          string      `json:"email"`
+		Email_Verified interface{} `json:"email_verified"`
+		Given_Name     string      `json:"given_name"`
+		Family_Name    string      `json:"family_name"`
+		Name           string      `json:"name"`
+	}
+	if err = idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+	identity := Identity{
+		Provider:   p.Name,
+		Subject:    idToken.Subject,
+		Email:      strings.TrimSpace(claims.Email),
+		First_Name: claims.Given_Name,
+		Last_Name:  claims.Family_Name,
+	}
+	//some providers send the flag as a string
+	switch verified := claims.Email_Verified.(type) {
+	case bool:
+		identity.Email_Verified = verified
+	case string:
+		identity.Email_Verified = verified == "true"
+	}
+	if identity.First_Name == "" && claims.Name != "" {
+		identity.First_Name, identity.Last_Name, _ = strings.Cut(claims.Name, " ")
+	}
+	return identity, nil
+}
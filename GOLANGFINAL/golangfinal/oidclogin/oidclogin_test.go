@@ -0,0 +1,160 @@
+package oidclogin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"golangfinal/oidclogin/oidctest"
+)
+
+const (
+	testState    = "state-of-the-login"
+	testNonce    = "nonce-of-the-login"
+	testVerifier = "verifier-of-the-login-0123456789abcdefghijklmn"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Issuer, *Provider) {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("shop", "shop-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	//the context of the start ends before the first login, like in main
+	ctx, cancel := context.WithCancel(context.Background())
+	provider, err := NewProvider(ctx, "test", issuer.URL, "shop", "shop-secret", "http://shop.example/users/oidc/test/callback", []string{"openid", "email"}, issuer.Client())
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer, provider
+}
+
+// signIn sends the user through the provider and returns the code of the callback
+func signIn(t *testing.T, issuer *oidctest.Issuer, provider *Provider, user map[string]interface{}) string {
+	t.Helper()
+	issuer.SetUser(user)
+	callback, err := issuer.SignIn(provider.AuthURL(testState, testNonce, testVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Get("state") != testState {
+		t.Fatalf("the provider sent back state %q, want %q", callback.Get("state"), testState)
+	}
+	return callback.Get("code")
+}
+
+func TestAuthURL(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	authURL, err := url.Parse(provider.AuthURL(testState, testNonce, testVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authURL.Scheme+"://"+authURL.Host != issuer.URL || authURL.Path != "/authorize" {
+		t.Errorf("auth url = %s, want the authorization endpoint of the discovery document", authURL)
+	}
+	query := authURL.Query()
+	sum := sha256.Sum256([]byte(testVerifier))
+	want := map[string]string{
+		"client_id":             "shop",
+		"redirect_uri":          "http://shop.example/users/oidc/test/callback",
+		"response_type":         "code",
+		"scope":                 "openid email",
+		"state":                 testState,
+		"nonce":                 testNonce,
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(sum[:]),
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if query.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, query.Get(name), value)
+		}
+	}
+	if query.Has("code_verifier") {
+		t.Error("the verifier is sent to the browser")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	code := signIn(t, issuer, provider, map[string]interface{}{
+		"sub": "1234", "email": " Ann@Example.com ", "email_verified": true, "given_name": "Ann", "family_name": "Smith",
+	})
+	identity, err := provider.Exchange(context.Background(), code, testVerifier, testNonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Provider: "test", Subject: "1234", Email: "Ann@Example.com", Email_Verified: true, First_Name: "Ann", Last_Name: "Smith"}
+	if identity != want {
+		t.Errorf("identity = %+v, want %+v", identity, want)
+	}
+	//the verifier of AuthURL reached the token endpoint, the provider checked it against the challenge
+	if verifiers := issuer.Verifiers(); len(verifiers) != 1 || verifiers[0] != testVerifier {
+		t.Errorf("the token endpoint got the verifiers %q, want %q", verifiers, testVerifier)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	code := signIn(t, issuer, provider, map[string]interface{}{"sub": "1234"})
+	if _, err := provider.Exchange(context.Background(), code, "another-verifier-0123456789abcdefghijklmnopq", testNonce); err == nil {
+		t.Error("a code is exchanged with another verifier")
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	code := signIn(t, issuer, provider, map[string]interface{}{"sub": "1234"})
+	//the id token carries the nonce of another login
+	if _, err := provider.Exchange(context.Background(), code, testVerifier, "nonce-of-another-login"); err != ErrNonceMismatch {
+		t.Errorf("error = %v, want %v", err, ErrNonceMismatch)
+	}
+}
+
+func TestExchangeOtherAudience(t *testing.T) {
+	issuer, provider := newTestProvider(t)
+	other, err := NewProvider(context.Background(), "test", issuer.URL, "another-client", "shop-secret", "http://shop.example/users/oidc/test/callback", nil, issuer.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.config.ClientID = "shop"
+	code := signIn(t, issuer, provider, map[string]interface{}{"sub": "1234"})
+	if _, err := other.Exchange(context.Background(), code, testVerifier, testNonce); err == nil {
+		t.Error("an id token for another client is accepted")
+	}
+}
+
+func TestExchangeEmailVerified(t *testing.T) {
+	tests := []struct {
+		claim    interface{}
+		verified bool
+	}{
+		{true, true},
+		{false, false},
+		//some providers send the flag as a string
+		{"true", true},
+		{"false", false},
+		{nil, false},
+	}
+	issuer, provider := newTestProvider(t)
+	for _, test := range tests {
+		user := map[string]interface{}{"sub": "1234", "email": "ann@example.com", "name": "Ann Smith"}
+		if test.claim != nil {
+			user["email_verified"] = test.claim
+		}
+		identity, err := provider.Exchange(context.Background(), signIn(t, issuer, provider, user), testVerifier, testNonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity.Email_Verified != test.verified {
+			t.Errorf("email_verified %#v: verified = %v, want %v", test.claim, identity.Email_Verified, test.verified)
+		}
+		//without given_name the name is split
+		if identity.First_Name != "Ann" || identity.Last_Name != "Smith" {
+			t.Errorf("name = %q %q, want Ann Smith", identity.First_Name, identity.Last_Name)
+		}
+	}
+}
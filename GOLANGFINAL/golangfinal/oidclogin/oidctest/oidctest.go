@@ -0,0 +1,189 @@
+/*
+Package oidctest is an OpenID provider for the tests of the logins.
+it serves the discovery document, the keys and the token endpoint like a real issuer,
+the user "signs in" with SignIn instead of a browser
+*/
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// the kid of the key the id tokens are signed with
+const keyID = "oidctest"
+
+var ErrNotAnAuthURL = errors.New("not an authorization url of the issuer")
+
+// Issuer is a running fake provider, Close stops it
+type Issuer struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	//the claims the id token of the next sign in carries besides iss, aud, exp, iat and nonce
+	user map[string]interface{}
+	//what the authorization request asked for, by code
+	logins map[string]login
+	//the code_verifier of every token request, in order
+	verifiers []string
+}
+
+type login struct {
+	nonce     string
+	challenge string
+	user      map[string]interface{}
+}
+
+// NewIssuer starts a provider for the client, the user signing in is set with SetUser
+func NewIssuer(clientID string, clientSecret string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	issuer := &Issuer{ClientID: clientID, ClientSecret: clientSecret, key: key, logins: make(map[string]login)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/keys", issuer.keys)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	return issuer, nil
+}
+
+// SetUser sets the claims of the user that signs in next, like sub, email and email_verified
+func (issuer *Issuer) SetUser(claims map[string]interface{}) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.user = claims
+}
+
+// Verifiers are the PKCE verifiers the client sent to the token endpoint
+func (issuer *Issuer) Verifiers() []string {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	return append([]string(nil), issuer.verifiers...)
+}
+
+/*
+SignIn does what the browser and the user do at the provider: it reads the authorization url,
+remembers its nonce and PKCE challenge and returns the query of the redirect back to the client
+*/
+func (issuer *Issuer) SignIn(authURL string) (url.Values, error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil || parsed.Path != "/authorize" {
+		return nil, ErrNotAnAuthURL
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != issuer.ClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		return nil, ErrNotAnAuthURL
+	}
+	code := randomCode()
+	issuer.mu.Lock()
+	issuer.logins[code] = login{nonce: query.Get("nonce"), challenge: query.Get("code_challenge"), user: issuer.user}
+	issuer.mu.Unlock()
+	return url.Values{"code": {code}, "state": {query.Get("state")}}, nil
+}
+
+func (issuer *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer.URL,
+		"authorization_endpoint":                issuer.URL + "/authorize",
+		"token_endpoint":                        issuer.URL + "/token",
+		"jwks_uri":                              issuer.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (issuer *Issuer) keys(w http.ResponseWriter, r *http.Request) {
+	public := issuer.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": keyID,
+		"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}}})
+}
+
+func (issuer *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	//oauth2 sends the secret with basic auth when the provider accepts it, otherwise in the form
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != issuer.ClientID || secret != issuer.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	verifier := r.PostForm.Get("code_verifier")
+	issuer.mu.Lock()
+	issuer.verifiers = append(issuer.verifiers, verifier)
+	signedIn, found := issuer.logins[r.PostForm.Get("code")]
+	delete(issuer.logins, r.PostForm.Get("code"))
+	issuer.mu.Unlock()
+	sum := sha256.Sum256([]byte(verifier))
+	if !found || base64.RawURLEncoding.EncodeToString(sum[:]) != signedIn.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{}
+	for name, value := range signedIn.user {
+		claims[name] = value
+	}
+	claims["iss"] = issuer.URL
+	claims["aud"] = issuer.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	if signedIn.nonce != "" {
+		claims["nonce"] = signedIn.nonce
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(issuer.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomCode(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func randomCode() string {
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	return base64.RawURLEncoding.EncodeToString(random)
+}
@@ -0,0 +1,240 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the hashing algorithms a Policy can use
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hashing algorithm")
+	ErrInvalidHash      = errors.New("the password hash is invalid")
+	ErrTooShort         = errors.New("the password is too short")
+	ErrTooLong          = errors.New("the password is too long")
+	ErrBreached         = errors.New("the password appears in a list of breached passwords, choose another one")
+	ErrContainsUserInfo = errors.New("the password must not be your name or email")
+)
+
+// Argon2Params are the parameters of argon2id, Memory is in KiB
+type Argon2Params struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+/*
+Policy is how passwords are hashed and which passwords are accepted.
+hashes made with another algorithm or other parameters still verify,
+NeedsRehash tells when one should be hashed again with the current policy
+*/
+type Policy struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+	MinLength  int
+	MaxLength  int
+	//lowercase passwords and the SHA-1 hex of passwords, like the lists of breached passwords
+	Breached map[string]struct{}
+}
+
+// Default is the policy used when nothing is configured
+func Default() *Policy {
+	return &Policy{
+		Algorithm:  Bcrypt,
+		BcryptCost: 14,
+		Argon2:     Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 2, KeyLength: 32, SaltLength: 16},
+		MinLength:  8,
+		MaxLength:  72,
+	}
+}
+
+// Current is the policy of the shop, main replaces it with the one from the environment
+var Current = Default()
+
+// Validate checks that the parameters can be used
+func (p *Policy) Validate() error {
+	switch p.Algorithm {
+	case Bcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		//bcrypt only looks at the first 72 bytes
+		if p.MaxLength > 72 {
+			return errors.New("bcrypt can't hash passwords longer than 72 bytes")
+		}
+	case Argon2id:
+		if p.Argon2.Time < 1 || p.Argon2.Memory < 8*uint32(p.Argon2.Threads) || p.Argon2.Threads < 1 {
+			return errors.New("argon2id needs time >= 1, threads >= 1 and memory >= 8 KiB per thread")
+		}
+		if p.Argon2.KeyLength < 16 || p.Argon2.SaltLength < 16 {
+			return errors.New("argon2id needs a key and a salt of at least 16 bytes")
+		}
+	default:
+		return ErrUnknownAlgorithm
+	}
+	if p.MinLength < 1 || p.MaxLength < p.MinLength {
+		return errors.New("the password length limits are invalid")
+	}
+	return nil
+}
+
+/*
+LoadBreached reads a list of breached passwords, one per line.
+a line can be the password itself or its SHA-1 in hex, optionally followed by ":count"
+like the downloads of Have I Been Pwned
+*/
+func LoadBreached(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	breached := make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if hash, _, found := strings.Cut(line, ":"); found && isSHA1(hash) {
+			line = hash
+		}
+		breached[strings.ToLower(line)] = struct{}{}
+	}
+	return breached, scanner.Err()
+}
+
+func isSHA1(text string) bool {
+	if len(text) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(text)
+	return err == nil
+}
+
+// Check tells if the password is allowed, userInfo are the name and email it must not be
+func (p *Policy) Check(password string, userInfo ...string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("%w, it needs at least %d characters", ErrTooShort, p.MinLength)
+	}
+	if len(password) > p.MaxLength {
+		return fmt.Errorf("%w, it can have at most %d bytes", ErrTooLong, p.MaxLength)
+	}
+	for _, info := range userInfo {
+		if info != "" && strings.EqualFold(password, info) {
+			return ErrContainsUserInfo
+		}
+	}
+	if len(p.Breached) > 0 {
+		sum := sha1.Sum([]byte(password))
+		if _, found := p.Breached[hex.EncodeToString(sum[:])]; found {
+			return ErrBreached
+		}
+		if _, found := p.Breached[strings.ToLower(password)]; found {
+			return ErrBreached
+		}
+	}
+	return nil
+}
+
+// Hash hashes the password with the algorithm of the policy
+func (p *Policy) Hash(password string) (string, error) {
+	switch p.Algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	case Argon2id:
+		salt := make([]byte, p.Argon2.SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Argon2.Time, p.Argon2.Memory, p.Argon2.Threads, p.Argon2.KeyLength)
+		//the PHC string format, the same as the argon2 command line tool
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Argon2.Memory, p.Argon2.Time, p.Argon2.Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	return "", ErrUnknownAlgorithm
+}
+
+// Verify tells if the password matches the hash, whatever policy the hash was made with
+func (p *Policy) Verify(password string, hash string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	return true, nil
+}
+
+// NeedsRehash tells if the hash was made with another algorithm or other parameters than the policy
+func (p *Policy) NeedsRehash(hash string) bool {
+	switch p.Algorithm {
+	case Bcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != p.BcryptCost
+	case Argon2id:
+		params, salt, key, err := parseArgon2id(hash)
+		return err != nil || params.Time != p.Argon2.Time || params.Memory != p.Argon2.Memory ||
+			params.Threads != p.Argon2.Threads || uint32(len(key)) != p.Argon2.KeyLength || uint32(len(salt)) != p.Argon2.SaltLength
+	}
+	return false
+}
+
+func parseArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(hash, "$")
+	//"", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[1] != Argon2id {
+		return params, nil, nil, ErrInvalidHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if params.Time < 1 || params.Threads < 1 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.KeyLength, params.SaltLength = uint32(len(key)), uint32(len(salt))
+	return params, salt, key, nil
+}
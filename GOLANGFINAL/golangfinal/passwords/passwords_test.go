@@ -0,0 +1,192 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fast policies, the default costs make the tests slow
+func testBcrypt() *Policy {
+	policy := Default()
+	policy.BcryptCost = bcrypt.MinCost
+	return policy
+}
+
+func testArgon2id() *Policy {
+	policy := Default()
+	policy.Algorithm = Argon2id
+	policy.Argon2 = Argon2Params{Time: 1, Memory: 64, Threads: 1, KeyLength: 16, SaltLength: 16}
+	policy.MaxLength = 128
+	return policy
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("the default policy is invalid: %v", err)
+	}
+	if err := testArgon2id().Validate(); err != nil {
+		t.Errorf("the argon2id policy is invalid: %v", err)
+	}
+	tests := []struct {
+		name   string
+		change func(*Policy)
+	}{
+		{"unknown algorithm", func(p *Policy) { p.Algorithm = "md5" }},
+		{"bcrypt cost too low", func(p *Policy) { p.BcryptCost = bcrypt.MinCost - 1 }},
+		{"bcrypt cost too high", func(p *Policy) { p.BcryptCost = bcrypt.MaxCost + 1 }},
+		{"bcrypt longer than 72 bytes", func(p *Policy) { p.MaxLength = 73 }},
+		{"argon2id without time", func(p *Policy) { p.Algorithm = Argon2id; p.Argon2.Time = 0 }},
+		{"argon2id without threads", func(p *Policy) { p.Algorithm = Argon2id; p.Argon2.Threads = 0 }},
+		{"argon2id with too little memory", func(p *Policy) { p.Algorithm = Argon2id; p.Argon2.Memory = 8 }},
+		{"argon2id with a short key", func(p *Policy) { p.Algorithm = Argon2id; p.Argon2.KeyLength = 8 }},
+		{"argon2id with a short salt", func(p *Policy) { p.Algorithm = Argon2id; p.Argon2.SaltLength = 8 }},
+		{"no minimum length", func(p *Policy) { p.MinLength = 0 }},
+		{"maximum below minimum", func(p *Policy) { p.MinLength = 20; p.MaxLength = 10 }},
+	}
+	for _, test := range tests {
+		policy := Default()
+		test.change(policy)
+		if err := policy.Validate(); err == nil {
+			t.Errorf("%s: the policy is valid", test.name)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	policy := Default()
+	policy.Breached = map[string]struct{}{"password1": {}, sha1Hex("correct horse"): {}}
+	tests := []struct {
+		password string
+		err      error
+	}{
+		{"s3cret-enough", nil},
+		{"short", ErrTooShort},
+		//8 characters but more bytes, the minimum counts characters
+		{"éééééééé", nil},
+		{strings.Repeat("a", 73), ErrTooLong},
+		{"Password1", ErrBreached},
+		{"correct horse", ErrBreached},
+		{"ann@example.com", ErrContainsUserInfo},
+		{"ANN@EXAMPLE.COM", ErrContainsUserInfo},
+		{"Ann Smith", ErrContainsUserInfo},
+	}
+	for _, test := range tests {
+		if err := policy.Check(test.password, "Ann Smith", "ann@example.com", ""); !errors.Is(err, test.err) {
+			t.Errorf("Check(%q) = %v, want %v", test.password, err, test.err)
+		}
+	}
+}
+
+func TestCheckBreachedSHA1(t *testing.T) {
+	//upper case with the counts, like the downloads of Have I Been Pwned
+	breached, err := loadTestList(t, "sha1.txt", strings.ToUpper(sha1Hex("hunter2-hunter2"))+":42\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := Default()
+	policy.Breached = breached
+	if err := policy.Check("hunter2-hunter2"); err != ErrBreached {
+		t.Errorf("a password listed by its SHA-1: error = %v, want %v", err, ErrBreached)
+	}
+	if err := policy.Check("hunter3-hunter3"); err != nil {
+		t.Errorf("a password that isn't on the list: %v", err)
+	}
+}
+
+func TestLoadBreached(t *testing.T) {
+	list := "# a comment\n\nPassword1\n" + strings.ToUpper(sha1Hex("letmein!")) + ":3861493\nnot:a-hash\n"
+	breached, err := loadTestList(t, "list.txt", list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"password1", sha1Hex("letmein!"), "not:a-hash"} {
+		if _, found := breached[entry]; !found {
+			t.Errorf("%q is not in the list", entry)
+		}
+	}
+	if len(breached) != 3 {
+		t.Errorf("the list has %d entries, want 3: %v", len(breached), breached)
+	}
+	if _, err := LoadBreached(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("a missing file gives no error")
+	}
+}
+
+func TestHashVerify(t *testing.T) {
+	for _, policy := range []*Policy{testBcrypt(), testArgon2id()} {
+		hash, err := policy.Hash("s3cret-enough")
+		if err != nil {
+			t.Fatalf("%s: %v", policy.Algorithm, err)
+		}
+		if ok, err := policy.Verify("s3cret-enough", hash); !ok || err != nil {
+			t.Errorf("%s: the right password = %v, %v", policy.Algorithm, ok, err)
+		}
+		if ok, err := policy.Verify("s3cret-enougH", hash); ok || err != nil {
+			t.Errorf("%s: a wrong password = %v, %v", policy.Algorithm, ok, err)
+		}
+		//two hashes of the same password have different salts
+		if other, _ := policy.Hash("s3cret-enough"); other == hash {
+			t.Errorf("%s: the same hash twice", policy.Algorithm)
+		}
+	}
+	//a hash made with the other algorithm still verifies
+	hash, _ := testArgon2id().Hash("s3cret-enough")
+	if ok, err := testBcrypt().Verify("s3cret-enough", hash); !ok || err != nil {
+		t.Errorf("an argon2id hash with the bcrypt policy = %v, %v", ok, err)
+	}
+	for _, hash := range []string{"", "plain", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5", "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0$a2V5"} {
+		if _, err := testBcrypt().Verify("s3cret-enough", hash); err != ErrInvalidHash {
+			t.Errorf("Verify(%q) error = %v, want %v", hash, err, ErrInvalidHash)
+		}
+	}
+	unknown := Default()
+	unknown.Algorithm = "md5"
+	if _, err := unknown.Hash("s3cret-enough"); err != ErrUnknownAlgorithm {
+		t.Errorf("hashing with an unknown algorithm: error = %v, want %v", err, ErrUnknownAlgorithm)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, _ := testBcrypt().Hash("s3cret-enough")
+	argonHash, _ := testArgon2id().Hash("s3cret-enough")
+	if testBcrypt().NeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash of the policy needs a rehash")
+	}
+	if testArgon2id().NeedsRehash(argonHash) {
+		t.Error("an argon2id hash of the policy needs a rehash")
+	}
+	if !testBcrypt().NeedsRehash(argonHash) || !testArgon2id().NeedsRehash(bcryptHash) {
+		t.Error("a hash of the other algorithm doesn't need a rehash")
+	}
+	higher := testBcrypt()
+	higher.BcryptCost++
+	if !higher.NeedsRehash(bcryptHash) {
+		t.Error("a bcrypt hash with a lower cost doesn't need a rehash")
+	}
+	more := testArgon2id()
+	more.Argon2.Time++
+	if !more.NeedsRehash(argonHash) {
+		t.Error("an argon2id hash with less time doesn't need a rehash")
+	}
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadTestList(t *testing.T, name string, content string) (map[string]struct{}, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadBreached(path)
+}
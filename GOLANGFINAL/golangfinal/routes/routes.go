@@ -2,16 +2,48 @@ package routes
 
 import (
 	"golangfinal/controllers"
+	"golangfinal/middleware"
+	token "golangfinal/tokens"
 
 	"github.com/gin-gonic/gin"
 )
 
 func UserRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/.well-known/jwks.json", controllers.JWKS())
 	incomingRoutes.POST("/users/signup", controllers.SignUp())
 	incomingRoutes.POST("/users/login", controllers.Login())
-	incomingRoutes.POST("/admin/addproduct", controllers.ProductViewerAdmin())
+	incomingRoutes.POST("/users/login/2fa", controllers.LoginTwoFactor())
+	incomingRoutes.GET("/users/oidc/providers", controllers.ListOIDCProviders())
+	incomingRoutes.GET("/users/oidc/:provider/login", controllers.OIDCLogin())
+	incomingRoutes.GET("/users/oidc/:provider/callback", controllers.OIDCCallback())
+	incomingRoutes.GET("/users/verify", controllers.VerifyEmail())
+	incomingRoutes.POST("/users/resendverification", controllers.ResendVerification())
+	incomingRoutes.POST("/users/forgotpassword", controllers.ForgotPassword())
+	incomingRoutes.GET("/users/resetpassword", controllers.ResetPasswordForm())
+	incomingRoutes.POST("/users/resetpassword", controllers.ResetPassword())
+	incomingRoutes.GET("/users/confirmemail", controllers.ConfirmEmailChange())
+	incomingRoutes.GET("/users/currencies", controllers.ListExchangeRates())
+	incomingRoutes.GET("/wishlists/shared/:token", controllers.SharedWishlist())
+	incomingRoutes.POST("/guest/addtocart", controllers.GuestAddToCart())
+	incomingRoutes.POST("/guest/removeitem", controllers.GuestRemoveItem())
+	incomingRoutes.GET("/guest/listcart", controllers.GuestListCart())
 	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
 	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
 	incomingRoutes.GET("/users/filterprice", controllers.FilterPrice())
 
 }
+
+// AdminRoutes are the routes of the shop admins, every route needs its own scope, admin covers them all
+func AdminRoutes(incomingRoutes *gin.RouterGroup) {
+	incomingRoutes.POST("/addproduct", middleware.RequireScope(token.ScopeProducts), controllers.ProductViewerAdmin())
+	incomingRoutes.DELETE("/unlockaccount", middleware.RequireScope(token.ScopeAccounts), controllers.UnlockAccount())
+	incomingRoutes.POST("/addtaxrule", middleware.RequireScope(token.ScopeTaxes), controllers.AddTaxRule())
+	incomingRoutes.GET("/taxrules", middleware.RequireScope(token.ScopeTaxes), controllers.ListTaxRules())
+	incomingRoutes.PUT("/edittaxrule", middleware.RequireScope(token.ScopeTaxes), controllers.EditTaxRule())
+	incomingRoutes.DELETE("/deletetaxrule", middleware.RequireScope(token.ScopeTaxes), controllers.DeleteTaxRule())
+	incomingRoutes.PUT("/exchangerate", middleware.RequireScope(token.ScopeRates), controllers.SetExchangeRate())
+	incomingRoutes.DELETE("/deleteexchangerate", middleware.RequireScope(token.ScopeRates), controllers.DeleteExchangeRate())
+	incomingRoutes.POST("/apikeys", middleware.RequireScope(token.ScopeAPIKeys), controllers.CreateAPIKey())
+	incomingRoutes.GET("/apikeys", middleware.RequireScope(token.ScopeAPIKeys), controllers.ListAPIKeys())
+	incomingRoutes.DELETE("/apikeys", middleware.RequireScope(token.ScopeAPIKeys), controllers.RevokeAPIKey())
+}
@@ -0,0 +1,214 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrEmptySecret  = errors.New("SECRET_LOVE is empty, set it or configure signing keys with JWT_KEYS_DIR")
+	ErrNoSigningKey = errors.New("no key to sign tokens with")
+	ErrUnknownKey   = errors.New("the token was signed with an unknown key")
+)
+
+// Key is one key tokens are signed or verified with, a key without the private part only verifies
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+/*
+KeySet holds the key new tokens are signed with and every key a token may still be verified with.
+to rotate, add the new key, make it the signing key and keep the old one
+until the tokens signed with it have expired
+*/
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// Keys are the keys of the tokens, main loads them with LoadKeys before the server starts
+var Keys *KeySet
+
+/*
+LoadKeys reads the keys of the configuration.
+with a dir every <kid>.pem in it is an RS256 or EdDSA key, private keys sign and verify,
+public keys only verify. signingKid picks the signing key, without it the last private key
+by name signs. without a dir the tokens are signed with HS256 and the secret, which then
+has to be set. when both are set the secret keeps verifying the older HS256 tokens
+*/
+func LoadKeys(secret string, dir string, signingKid string) (*KeySet, error) {
+	if dir == "" {
+		if secret == "" {
+			return nil, ErrEmptySecret
+		}
+		hmac := &Key{Method: jwt.SigningMethodHS256, private: []byte(secret), public: []byte(secret)}
+		return &KeySet{signing: hmac, keys: map[string]*Key{"": hmac}}, nil
+	}
+	set, err := LoadKeyDir(dir, signingKid)
+	if err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		//the tokens signed before the keys were set up have no kid
+		set.keys[""] = &Key{Method: jwt.SigningMethodHS256, public: []byte(secret)}
+	}
+	return set, nil
+}
+
+// LoadKeyDir reads the <kid>.pem files of the directory
+func LoadKeyDir(dir string, signingKid string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	set := &KeySet{keys: make(map[string]*Key)}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		kid = strings.TrimSuffix(kid, ".pub")
+		key, err := readKey(kid, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		//a private key and its public file share the kid, the private one wins
+		if old, found := set.keys[kid]; found && old.private != nil {
+			continue
+		}
+		set.keys[kid] = key
+		if key.private != nil && (signingKid == "" || signingKid == kid) {
+			set.signing = key
+		}
+	}
+	if set.signing == nil {
+		return nil, fmt.Errorf("%w in %s", ErrNoSigningKey, dir)
+	}
+	return set, nil
+}
+
+func readKey(kid string, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+	if public, ok := key.public.(*rsa.PublicKey); ok && public.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys need at least 2048 bits")
+	}
+	return key, nil
+}
+
+// sign signs the claims with the signing key and names the key in the kid header
+func (set *KeySet) sign(claims jwt.Claims) (string, error) {
+	if set == nil || set.signing == nil {
+		return "", ErrNoSigningKey
+	}
+	token := jwt.NewWithClaims(set.signing.Method, claims)
+	if set.signing.ID != "" {
+		token.Header["kid"] = set.signing.ID
+	}
+	return token.SignedString(set.signing.private)
+}
+
+// keyFunc finds the key of the kid header, the token has to use the algorithm of that key
+func (set *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	if set == nil {
+		return nil, ErrNoSigningKey
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, found := set.keys[kid]
+	if !found {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+func (set *KeySet) parse(signedtoken string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(signedtoken, claims, set.keyFunc)
+}
+
+// JWK is a public key as in RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS lists the public keys other services can verify our tokens with, HS256 secrets are never in it
+func (set *KeySet) JWKS() map[string][]JWK {
+	keys := make([]JWK, 0)
+	if set != nil {
+		ids := make([]string, 0, len(set.keys))
+		for id := range set.keys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			key := set.keys[id]
+			switch public := key.public.(type) {
+			case *rsa.PublicKey:
+				keys = append(keys, JWK{Kty: "RSA", Kid: key.ID, Use: "sig", Alg: key.Method.Alg(),
+					N: base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+					E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())})
+			case ed25519.PublicKey:
+				keys = append(keys, JWK{Kty: "OKP", Kid: key.ID, Use: "sig", Alg: key.Method.Alg(), Crv: "Ed25519",
+					X: base64.RawURLEncoding.EncodeToString(public)})
+			}
+		}
+	}
+	return map[string][]JWK{"keys": keys}
+}
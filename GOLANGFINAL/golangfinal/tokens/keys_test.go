@@ -0,0 +1,234 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	rsaKey, _        = rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _      = ed25519.GenerateKey(rand.Reader)
+	otherRSAKey, _   = rsa.GenerateKey(rand.Reader, 2048)
+	testClaimsExpiry = time.Now().Add(time.Hour).Unix()
+)
+
+// writeKey writes the key into dir as <kid>.pem, public keys as PKIX and private ones as PKCS #8
+func writeKey(t *testing.T, dir string, kid string, key interface{}) {
+	t.Helper()
+	var block *pem.Block
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClaims() *SignedDetails {
+	return &SignedDetails{Uid: "user", StandardClaims: jwt.StandardClaims{ExpiresAt: testClaimsExpiry}}
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "a-rsa", rsaKey)
+	writeKey(t, dir, "b-ed", edKey)
+	writeKey(t, dir, "c-public", &otherRSAKey.PublicKey)
+
+	tests := []struct {
+		name            string
+		secret, keysDir string
+		signingKid      string
+		wantAlg         string
+		wantKid         string
+		wantErr         error
+	}{
+		{"nothing set", "", "", "", "", "", ErrEmptySecret},
+		{"only the secret", "secret", "", "", "HS256", "", nil},
+		{"last private key signs", "", dir, "", "EdDSA", "b-ed", nil},
+		{"signing kid", "", dir, "a-rsa", "RS256", "a-rsa", nil},
+		{"keys and the secret", "secret", dir, "a-rsa", "RS256", "a-rsa", nil},
+		//a public key can't sign
+		{"public signing kid", "", dir, "c-public", "", "", ErrNoSigningKey},
+		{"empty dir", "secret", t.TempDir(), "", "", "", ErrNoSigningKey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := LoadKeys(test.secret, test.keysDir, test.signingKid)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("error = %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			signed, err := set.sign(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := set.parse(signed, &SignedDetails{})
+			if err != nil {
+				t.Fatalf("a token of the set doesn't verify: %v", err)
+			}
+			kid, _ := token.Header["kid"].(string)
+			if token.Method.Alg() != test.wantAlg || kid != test.wantKid {
+				t.Errorf("signed with %s kid %q, want %s kid %q", token.Method.Alg(), kid, test.wantAlg, test.wantKid)
+			}
+		})
+	}
+}
+
+func TestHS256Fallback(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "rsa", rsaKey)
+	old, err := LoadKeys("secret", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := old.sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//after the keys are set up the old HS256 tokens keep working as long as the secret is set
+	rotated, err := LoadKeys("secret", dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.parse(oldToken, &SignedDetails{}); err != nil {
+		t.Errorf("the HS256 token doesn't verify after the rotation: %v", err)
+	}
+	//but the secret only verifies, it never signs again
+	signed, _ := rotated.sign(testClaims())
+	if _, err := old.parse(signed, &SignedDetails{}); err == nil {
+		t.Error("a new token verifies with the secret alone")
+	}
+
+	withoutSecret, err := LoadKeys("", dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withoutSecret.parse(oldToken, &SignedDetails{}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("HS256 token without the secret: error = %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "rsa", rsaKey)
+	writeKey(t, dir, "ed", edKey)
+	set, err := LoadKeyDir(dir, "rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	signWith := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, testClaims())
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name   string
+		signed string
+	}{
+		{"unknown kid", signWith(jwt.SigningMethodRS256, "gone", rsaKey)},
+		{"no kid without a secret", signWith(jwt.SigningMethodHS256, "", []byte("secret"))},
+		{"alg none", signWith(jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType)},
+		{"alg none without kid", signWith(jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType)},
+		//the public key is no secret, an HS256 token made with it must not pass as the RSA key
+		{"HS256 with the RSA public key", signWith(jwt.SigningMethodHS256, "rsa", publicPEM)},
+		{"RS256 with the kid of the EdDSA key", signWith(jwt.SigningMethodRS256, "ed", rsaKey)},
+		{"EdDSA with the kid of the RSA key", signWith(jwt.SigningMethodEdDSA, "rsa", edKey)},
+		{"RS256 with another RSA key", signWith(jwt.SigningMethodRS256, "rsa", otherRSAKey)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := set.parse(test.signed, &SignedDetails{}); err == nil {
+				t.Error("the token was accepted")
+			}
+		})
+	}
+
+	Keys = set
+	t.Cleanup(func() { Keys = nil })
+	if claims, err := ValidateToken(tests[0].signed); claims != nil || !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateToken accepted a token of an unknown key: %+v", claims)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "rsa", rsaKey)
+	writeKey(t, dir, "ed", edKey)
+	writeKey(t, dir, "old", &otherRSAKey.PublicKey)
+	set, err := LoadKeys("secret", dir, "rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := set.JWKS()["keys"]
+	//sorted by kid, the HS256 secret is never published
+	if len(keys) != 3 || keys[0].Kid != "ed" || keys[1].Kid != "old" || keys[2].Kid != "rsa" {
+		t.Fatalf("JWKS = %+v, want ed, old and rsa", keys)
+	}
+	ed := keys[0]
+	if ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" || ed.N != "" {
+		t.Errorf("ed = %+v", ed)
+	}
+	if x, _ := base64.RawURLEncoding.DecodeString(ed.X); !edKey.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		t.Errorf("ed x = %s, not the public key", ed.X)
+	}
+	for _, test := range []struct {
+		jwk JWK
+		key *rsa.PublicKey
+	}{{keys[1], &otherRSAKey.PublicKey}, {keys[2], &rsaKey.PublicKey}} {
+		if test.jwk.Kty != "RSA" || test.jwk.Alg != "RS256" || test.jwk.Use != "sig" || test.jwk.X != "" {
+			t.Errorf("%s = %+v", test.jwk.Kid, test.jwk)
+		}
+		n, _ := base64.RawURLEncoding.DecodeString(test.jwk.N)
+		e, _ := base64.RawURLEncoding.DecodeString(test.jwk.E)
+		if new(big.Int).SetBytes(n).Cmp(test.key.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != test.key.E {
+			t.Errorf("%s n/e are not the public key", test.jwk.Kid)
+		}
+	}
+
+	if keys := (&KeySet{}).JWKS()["keys"]; keys == nil || len(keys) != 0 {
+		t.Errorf("an empty set publishes %v, want an empty list", keys)
+	}
+	hmac, _ := LoadKeys("secret", "", "")
+	if keys := hmac.JWKS()["keys"]; len(keys) != 0 {
+		t.Errorf("the HS256 set publishes %v", keys)
+	}
+}
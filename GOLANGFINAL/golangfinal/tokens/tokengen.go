@@ -1,99 +1,232 @@
 package token
 
 import (
-	"context"
-	"log"
-	"os"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	"golangfinal/database"
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrInvalidToken = errors.New("the token is invalid")
+	ErrTokenExpired = errors.New("the token is expired")
+)
+
+// how long the tokens are valid, main sets them from the configuration
+var (
+	AccessTokenLifetime  = 24 * time.Hour
+	RefreshTokenLifetime = 168 * time.Hour
+	GuestCartLifetime    = 720 * time.Hour
+)
+
+/*
+the audience of a token says what kind it is. every kind is signed with the same keys,
+so each Validate only takes its own kind, a refresh token or a guest cart token is no login
+*/
+const (
+	audienceAccess     = "access"
+	audienceRefresh    = "refresh"
+	audienceGuestCart  = "guest_cart"
+	audienceChallenge  = "2fa_challenge"
+	audienceLoginState = "login_state"
+)
 
-	jwt "github.com/dgrijalva/jwt-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+// the scopes of the admin routes, ScopeAdmin covers all of them
+const (
+	ScopeAdmin    = "admin"
+	ScopeProducts = "products:write"
+	ScopeTaxes    = "taxes:write"
+	ScopeRates    = "rates:write"
+	ScopeAccounts = "accounts:write"
+	ScopeAPIKeys  = "apikeys:write"
 )
 
+// Scopes are the scopes tokens and api keys can be given
+var Scopes = []string{ScopeAdmin, ScopeProducts, ScopeTaxes, ScopeRates, ScopeAccounts, ScopeAPIKeys}
+
+// KnownScope tells if the scope is one of Scopes
+func KnownScope(scope string) bool {
+	for _, known := range Scopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeGranted tells if the granted scopes allow the scope
+func ScopeGranted(granted []string, scope string) bool {
+	for _, g := range granted {
+		if g == scope || g == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
 type SignedDetails struct {
 	Email      string
 	First_Name string
 	Last_Name  string
 	Uid        string
+	//the login the token belongs to, logging the session out revokes the token
+	Session_ID string
+	//what the token may be used for, separated by spaces like in OAuth
+	Scope string `json:"scope,omitempty"`
 	jwt.StandardClaims
 }
 
-var UserData *mongo.Collection = database.UserData(database.Client, "Users")
-var SECRET_KEY = os.Getenv("SECRET_LOVE")
-
-func TokenGenerator(email string, firstname string, lastname string, uid string) (signedtoken string, signedrefreshtoken string, err error) {
+func TokenGenerator(email string, firstname string, lastname string, uid string, sessionid string, scopes []string) (signedtoken string, signedrefreshtoken string, err error) {
 	claims := &SignedDetails{
 		Email:      email,
 		First_Name: firstname,
 		Last_Name:  lastname,
 		Uid:        uid,
+		Session_ID: sessionid,
+		Scope:      strings.Join(scopes, " "),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			Audience:  audienceAccess,
+			ExpiresAt: time.Now().Local().Add(AccessTokenLifetime).Unix(),
 		},
 	}
 	refreshclaims := &SignedDetails{
+		Session_ID: sessionid,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Audience:  audienceRefresh,
+			ExpiresAt: time.Now().Local().Add(RefreshTokenLifetime).Unix(),
 		},
 	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := Keys.sign(claims)
 	if err != nil {
 		return "", "", err
 	}
-	refreshtoken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshclaims).SignedString([]byte(SECRET_KEY))
+	refreshtoken, err := Keys.sign(refreshclaims)
 	if err != nil {
-		log.Panicln(err)
-		return
+		return "", "", err
 	}
-	return token, refreshtoken, err
+	return token, refreshtoken, nil
 }
 
-func ValidateToken(signedtoken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(signedtoken, &SignedDetails{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
-	})
-
+func ValidateToken(signedtoken string) (*SignedDetails, error) {
+	token, err := Keys.parse(signedtoken, &SignedDetails{})
 	if err != nil {
-		msg = err.Error()
-		return
+		return nil, parseError(err)
 	}
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
-		msg = "The Token is invalid"
-		return
+	if !ok || !claims.VerifyAudience(audienceAccess, true) || claims.Uid == "" {
+		return nil, ErrInvalidToken
 	}
-	if claims.ExpiresAt < time.Now().Local().Unix() {
-		msg = "token is expired"
-		return
+	return claims, nil
+}
+
+// parseError turns the errors of the jwt package into ErrTokenExpired or ErrInvalidToken
+func parseError(err error) error {
+	var validation *jwt.ValidationError
+	if errors.As(err, &validation) && validation.Errors&jwt.ValidationErrorExpired != 0 {
+		return ErrTokenExpired
+	}
+	return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+}
+
+// GuestCartDetails are the claims of the token that identifies the cart of a visitor
+type GuestCartDetails struct {
+	Cart_ID string
+	jwt.StandardClaims
+}
+
+// GuestCartTokenGenerator signs the id of a guest cart, the cart lives for GuestCartLifetime
+func GuestCartTokenGenerator(cartid string) (string, error) {
+	claims := &GuestCartDetails{
+		Cart_ID: cartid,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audienceGuestCart,
+			ExpiresAt: time.Now().Local().Add(GuestCartLifetime).Unix(),
+		},
 	}
-	return claims, msg
+	return Keys.sign(claims)
 }
 
-func UpdateAllTokens(signedtoken string, signedrefreshtoken string, userid string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-	var updateobj primitive.D
-	updateobj = append(updateobj, bson.E{Key: "token", Value: signedtoken})
-	updateobj = append(updateobj, bson.E{Key: "refresh_token", Value: signedrefreshtoken})
-	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateobj = append(updateobj, bson.E{Key: "updatedat", Value: updated_at})
-	upsert := true
-	filter := bson.M{"user_id": userid}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-	_, err := UserData.UpdateOne(ctx, filter, bson.D{
-		{Key: "$set", Value: updateobj},
-	},
-		&opt)
-	defer cancel()
+// ValidateGuestCartToken returns the id of the cart the token was signed for
+func ValidateGuestCartToken(signedtoken string) (string, error) {
+	token, err := Keys.parse(signedtoken, &GuestCartDetails{})
 	if err != nil {
-		log.Panic(err)
-		return
+		return "", parseError(err)
 	}
+	claims, ok := token.Claims.(*GuestCartDetails)
+	if !ok || !claims.VerifyAudience(audienceGuestCart, true) || claims.Cart_ID == "" {
+		return "", ErrInvalidToken
+	}
+	return claims.Cart_ID, nil
+}
+
+// ChallengeDetails are the claims of the token between the password and the second factor of a login
+type ChallengeDetails struct {
+	Challenge_Uid string
+	Token_Version int
+	jwt.StandardClaims
+}
+
+// ChallengeTokenGenerator signs the id of a user that gave the right password, the second step has 5 minutes
+func ChallengeTokenGenerator(uid string, tokenversion int) (string, error) {
+	claims := &ChallengeDetails{
+		Challenge_Uid: uid,
+		Token_Version: tokenversion,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audienceChallenge,
+			ExpiresAt: time.Now().Local().Add(5 * time.Minute).Unix(),
+		},
+	}
+	return Keys.sign(claims)
+}
+
+// ValidateChallengeToken returns the user the challenge was signed for
+func ValidateChallengeToken(signedtoken string) (*ChallengeDetails, error) {
+	token, err := Keys.parse(signedtoken, &ChallengeDetails{})
+	if err != nil {
+		return nil, parseError(err)
+	}
+	claims, ok := token.Claims.(*ChallengeDetails)
+	if !ok || !claims.VerifyAudience(audienceChallenge, true) || claims.Challenge_Uid == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
 
+// LoginStateDetails are the claims of the cookie that remembers a sign in with a provider until its callback
+type LoginStateDetails struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	jwt.StandardClaims
+}
+
+// LoginStateTokenGenerator signs the state of a sign in with a provider, the user has 10 minutes to finish it
+func LoginStateTokenGenerator(provider string, state string, nonce string, verifier string) (string, error) {
+	claims := &LoginStateDetails{
+		Provider: provider,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  audienceLoginState,
+			ExpiresAt: time.Now().Local().Add(10 * time.Minute).Unix(),
+		},
+	}
+	return Keys.sign(claims)
+}
+
+// ValidateLoginStateToken returns the state of the sign in
+func ValidateLoginStateToken(signedtoken string) (*LoginStateDetails, error) {
+	token, err := Keys.parse(signedtoken, &LoginStateDetails{})
+	if err != nil {
+		return nil, parseError(err)
+	}
+	claims, ok := token.Claims.(*LoginStateDetails)
+	if !ok || !claims.VerifyAudience(audienceLoginState, true) || claims.State == "" || claims.Provider == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
 }
@@ -0,0 +1,82 @@
+package token
+
+import (
+	"testing"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+func TestTokenAudience(t *testing.T) {
+	keys, err := LoadKeys("secret", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Keys = keys
+	t.Cleanup(func() { Keys = nil })
+
+	access, refresh, err := TokenGenerator("ann@example.com", "Ann", "Smith", "user", "session", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart, err := GuestCartTokenGenerator("cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := ChallengeTokenGenerator("user", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoginStateTokenGenerator("google", "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a token that has every claim any kind needs, but no audience
+	untyped, err := keys.sign(&struct {
+		SignedDetails
+		Cart_ID       string
+		Challenge_Uid string
+		Provider      string
+		State         string
+	}{SignedDetails{Uid: "user", StandardClaims: jwt.StandardClaims{ExpiresAt: testClaimsExpiry}}, "cart", "user", "google", "state"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validators := map[string]func(string) error{
+		"access": func(signed string) error {
+			_, err := ValidateToken(signed)
+			return err
+		},
+		"guest cart": func(signed string) error {
+			_, err := ValidateGuestCartToken(signed)
+			return err
+		},
+		"challenge": func(signed string) error {
+			_, err := ValidateChallengeToken(signed)
+			return err
+		},
+		"login state": func(signed string) error {
+			_, err := ValidateLoginStateToken(signed)
+			return err
+		},
+	}
+	tokens := map[string]string{
+		"access":      access,
+		"refresh":     refresh,
+		"guest cart":  cart,
+		"challenge":   challenge,
+		"login state": state,
+		"untyped":     untyped,
+	}
+	for kind, signed := range tokens {
+		for validator, validate := range validators {
+			err := validate(signed)
+			if kind == validator && err != nil {
+				t.Errorf("the %s token isn't valid as one: %v", kind, err)
+			}
+			if kind != validator && err == nil {
+				t.Errorf("the %s token is valid as a %s token", kind, validator)
+			}
+		}
+	}
+}
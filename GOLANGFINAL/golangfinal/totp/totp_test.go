@@ -0,0 +1,117 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 key of the test vectors of RFC 6238, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// the vectors of RFC 6238 appendix B, the last 6 of the 8 digits given there
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, vector := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(vector.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != vector.code {
+			t.Errorf("code at %d = %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	//the apps show the secret in groups and lower case, it has to work the same
+	code, err := Code(" "+strings.ToLower(rfcSecret)+" ", Step(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Errorf("lower case secret: code = %s, %v, want 287082", code, err)
+	}
+	for _, secret := range []string{"", "not base32!", "1"} {
+		if _, err := Code(secret, 1); err != ErrInvalidSecret {
+			t.Errorf("Code(%q) error = %v, want %v", secret, err, ErrInvalidSecret)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	tests := []struct {
+		name string
+		at   int64
+		ok   bool
+	}{
+		{"current period", step, true},
+		{"one period early", step - 1, true},
+		{"one period late", step + 1, true},
+		{"two periods early", step - 2, false},
+		{"two periods late", step + 2, false},
+	}
+	for _, test := range tests {
+		code, err := Code(rfcSecret, test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matched, ok := Validate(rfcSecret, code, now)
+		if ok != test.ok {
+			t.Errorf("%s: ok = %v, want %v", test.name, ok, test.ok)
+		}
+		if ok && matched != test.at {
+			t.Errorf("%s: matched step %d, want %d", test.name, matched, test.at)
+		}
+	}
+	if _, ok := Validate(rfcSecret, "050 471", now); !ok {
+		t.Error("a code with a space in the middle is rejected")
+	}
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("code %q is accepted", code)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	first, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := GenerateSecret()
+	if first == second {
+		t.Error("two secrets are the same")
+	}
+	if key, err := encoding.DecodeString(first); err != nil || len(key) != 20 {
+		t.Errorf("secret %q is not 160 bits of base32: %v", first, err)
+	}
+	if _, err := Code(first, 1); err != nil {
+		t.Errorf("a generated secret can't make codes: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Golang Shop", "ann@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Golang Shop:ann@example.com" {
+		t.Errorf("uri = %s", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "Golang Shop" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("query = %v", query)
+	}
+}
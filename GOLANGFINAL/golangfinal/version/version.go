@@ -0,0 +1,51 @@
+/*
+Package version tells which build of the shop is running. the values are set when building:
+
+	go build -ldflags "-X golangfinal/version.Version=v1.4.0 -X golangfinal/version.Commit=$(git rev-parse HEAD) -X golangfinal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+
+without them the commit and its time come from what the go tool records of the checkout
+*/
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// set with -ldflags -X, see the package comment
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info is the build the server was made from
+type Info struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	Build_Time string `json:"build_time"`
+	Modified   bool   `json:"modified,omitempty"`
+	Go_Version string `json:"go_version"`
+}
+
+// Get returns the build info
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Build_Time: BuildTime, Go_Version: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.Build_Time == "" {
+					info.Build_Time = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return info
+}